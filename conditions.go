@@ -0,0 +1,86 @@
+// Copyright 2020 Ohio Supercomputer Center
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+const (
+	reasonPending          string = "pending"
+	reasonUnschedulable    string = "unschedulable"
+	reasonImagePullBackOff string = "image-pull-backoff"
+	reasonCrashLoopBackOff string = "crashloop-backoff"
+	eventReasonStuck       string = "StuckCleanup"
+)
+
+var (
+	// conditionReasons are the reasons of pods reaped because they are stuck
+	conditionReasons = []string{reasonPending, reasonUnschedulable, reasonImagePullBackOff, reasonCrashLoopBackOff}
+)
+
+// podCondition returns the rule of the first condition the pod has been stuck in for longer
+// than its threshold, unschedulable and image pulls are checked before the more general pending
+func podCondition(pod *v1.Pod) (string, bool) {
+	if pod.Status.Phase != v1.PodPending && pod.Status.Phase != v1.PodRunning {
+		return "", false
+	}
+	now := timeNow()
+	created := pod.CreationTimestamp.Time
+	if *reapUnschedulableAfter > 0 {
+		for _, condition := range pod.Status.Conditions {
+			if condition.Type != v1.PodScheduled || condition.Status != v1.ConditionFalse || condition.Reason != v1.PodReasonUnschedulable {
+				continue
+			}
+			since := condition.LastTransitionTime.Time
+			if since.IsZero() {
+				since = created
+			}
+			if now.Sub(since) > *reapUnschedulableAfter {
+				return reasonUnschedulable, true
+			}
+		}
+	}
+	statuses := append(append([]v1.ContainerStatus{}, pod.Status.InitContainerStatuses...), pod.Status.ContainerStatuses...)
+	for _, status := range statuses {
+		if status.State.Waiting == nil {
+			continue
+		}
+		switch status.State.Waiting.Reason {
+		case "ImagePullBackOff", "ErrImagePull":
+			if *reapImagePullFailingAfter > 0 && now.Sub(waitingSince(pod, status)) > *reapImagePullFailingAfter {
+				return reasonImagePullBackOff, true
+			}
+		case "CrashLoopBackOff":
+			if *reapCrashLoopRestarts > 0 && int(status.RestartCount) > *reapCrashLoopRestarts {
+				return reasonCrashLoopBackOff, true
+			}
+		}
+	}
+	if *reapPendingAfter > 0 && pod.Status.Phase == v1.PodPending && now.Sub(created) > *reapPendingAfter {
+		return reasonPending, true
+	}
+	return "", false
+}
+
+// waitingSince returns when a waiting container last changed state, images are only pulled when a
+// container starts so this is when it last terminated or, if it never ran, when the pod was created
+func waitingSince(pod *v1.Pod, status v1.ContainerStatus) time.Time {
+	if terminated := status.LastTerminationState.Terminated; terminated != nil && !terminated.FinishedAt.IsZero() {
+		return terminated.FinishedAt.Time
+	}
+	return pod.CreationTimestamp.Time
+}
@@ -0,0 +1,165 @@
+// Copyright 2020 Ohio Supercomputer Center
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestPodCondition(t *testing.T) {
+	args := []string{"--reap-pending-after=2h", "--reap-unschedulable-after=30m", "--reap-image-pull-failing-after=30m", "--reap-crashloop-restarts=10"}
+	if _, err := kingpin.CommandLine.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	now, _ := time.Parse("01/02/2006 15:04:05", "01/01/2020 15:00:00")
+	timeNow = func() time.Time {
+		return now
+	}
+	waiting := func(reason string, restarts int32) []v1.ContainerStatus {
+		return []v1.ContainerStatus{{Name: "main", RestartCount: restarts, State: v1.ContainerState{Waiting: &v1.ContainerStateWaiting{Reason: reason}}}}
+	}
+	restarted := func(reason string, finished time.Time) []v1.ContainerStatus {
+		return []v1.ContainerStatus{{Name: "main", RestartCount: 1, State: v1.ContainerState{Waiting: &v1.ContainerStateWaiting{Reason: reason}},
+			LastTerminationState: v1.ContainerState{Terminated: &v1.ContainerStateTerminated{FinishedAt: metav1.NewTime(finished)}}}}
+	}
+	unschedulable := func(since time.Time) []v1.PodCondition {
+		return []v1.PodCondition{{Type: v1.PodScheduled, Status: v1.ConditionFalse, Reason: v1.PodReasonUnschedulable,
+			LastTransitionTime: metav1.NewTime(since)}}
+	}
+	tests := []struct {
+		created time.Duration
+		status  v1.PodStatus
+		reason  string
+	}{
+		{time.Hour, v1.PodStatus{Phase: v1.PodPending}, ""},
+		{3 * time.Hour, v1.PodStatus{Phase: v1.PodPending}, reasonPending},
+		{3 * time.Hour, v1.PodStatus{Phase: v1.PodRunning}, ""},
+		{time.Hour, v1.PodStatus{Phase: v1.PodPending, Conditions: unschedulable(now.Add(-time.Hour))}, reasonUnschedulable},
+		{time.Hour, v1.PodStatus{Phase: v1.PodPending, Conditions: unschedulable(now.Add(-10 * time.Minute))}, ""},
+		{time.Hour, v1.PodStatus{Phase: v1.PodPending, ContainerStatuses: waiting("ImagePullBackOff", 0)}, reasonImagePullBackOff},
+		{time.Hour, v1.PodStatus{Phase: v1.PodPending, InitContainerStatuses: waiting("ErrImagePull", 0)}, reasonImagePullBackOff},
+		{10 * time.Minute, v1.PodStatus{Phase: v1.PodPending, ContainerStatuses: waiting("ImagePullBackOff", 0)}, ""},
+		{3 * time.Hour, v1.PodStatus{Phase: v1.PodRunning, ContainerStatuses: restarted("ErrImagePull", now.Add(-time.Minute))}, ""},
+		{3 * time.Hour, v1.PodStatus{Phase: v1.PodRunning, ContainerStatuses: restarted("ImagePullBackOff", now.Add(-time.Hour))}, reasonImagePullBackOff},
+		{time.Hour, v1.PodStatus{Phase: v1.PodRunning, ContainerStatuses: waiting("CrashLoopBackOff", 11)}, reasonCrashLoopBackOff},
+		{time.Hour, v1.PodStatus{Phase: v1.PodRunning, ContainerStatuses: waiting("CrashLoopBackOff", 10)}, ""},
+		{3 * time.Hour, v1.PodStatus{Phase: v1.PodFailed, Reason: "Evicted"}, ""},
+	}
+	for i, test := range tests {
+		pod := &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{CreationTimestamp: metav1.NewTime(now.Add(-test.created))},
+			Status:     test.status,
+		}
+		reason, ok := podCondition(pod)
+		if reason != test.reason || ok != (test.reason != "") {
+			t.Errorf("Unexpected condition for test %d, got: %q", i, reason)
+		}
+	}
+
+	if _, err := kingpin.CommandLine.Parse([]string{}); err != nil {
+		t.Fatal(err)
+	}
+	for i, test := range tests {
+		pod := &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{CreationTimestamp: metav1.NewTime(now.Add(-test.created))},
+			Status:     test.status,
+		}
+		if reason, ok := podCondition(pod); ok {
+			t.Errorf("Expected rules to be disabled by default for test %d, got: %q", i, reason)
+		}
+	}
+}
+
+func TestGetJobsStuckPods(t *testing.T) {
+	if _, err := kingpin.CommandLine.Parse([]string{"--reap-pending-after=30m", "--reap-crashloop-restarts=10"}); err != nil {
+		t.Fatal(err)
+	}
+	w := log.NewSyncWriter(os.Stderr)
+	logger := log.NewLogfmtLogger(w)
+
+	labels := "app.kubernetes.io/managed-by=open-ondemand"
+	podsLabels = &labels
+
+	now, _ := time.Parse("01/02/2006 15:04:05", "01/01/2020 15:00:00")
+	timeNow = func() time.Time {
+		return now
+	}
+	created := metav1.NewTime(now.Add(-time.Hour))
+	clientset := fake.NewSimpleClientset(&v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              "ondemand-job1",
+			Namespace:         "user-user1",
+			CreationTimestamp: created,
+			Labels: map[string]string{
+				"job":                          "1",
+				"app.kubernetes.io/managed-by": "open-ondemand",
+			},
+		},
+		Status: v1.PodStatus{Phase: v1.PodPending},
+	}, &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              "ondemand-job2",
+			Namespace:         "user-user1",
+			CreationTimestamp: created,
+			Annotations: map[string]string{
+				"pod.kubernetes.io/lifetime": "4h",
+			},
+			Labels: map[string]string{
+				"job":                          "2",
+				"app.kubernetes.io/managed-by": "open-ondemand",
+			},
+		},
+		Status: v1.PodStatus{
+			Phase:             v1.PodRunning,
+			StartTime:         &created,
+			ContainerStatuses: []v1.ContainerStatus{{Name: "main", RestartCount: 12, State: v1.ContainerState{Waiting: &v1.ContainerStateWaiting{Reason: "CrashLoopBackOff"}}}},
+		},
+	}, &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              "ondemand-job3",
+			Namespace:         "user-user1",
+			CreationTimestamp: created,
+			Annotations: map[string]string{
+				"pod.kubernetes.io/lifetime": "4h",
+			},
+			Labels: map[string]string{
+				"job":                          "3",
+				"app.kubernetes.io/managed-by": "open-ondemand",
+			},
+		},
+		Status: v1.PodStatus{Phase: v1.PodRunning, StartTime: &created},
+	})
+	jobs, err := getJobs(context.TODO(), clientset, []string{"user-user1"}, logger)
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if len(jobs) != 2 {
+		t.Fatalf("Expected 2 jobs, got %d", len(jobs))
+	}
+	if jobs[0].jobID != "1" || jobs[0].reason != reasonPending {
+		t.Errorf("Unexpected job, got: %v reason %s", jobs[0].jobID, jobs[0].reason)
+	}
+	if jobs[1].jobID != "2" || jobs[1].reason != reasonCrashLoopBackOff {
+		t.Errorf("Unexpected job, got: %v reason %s", jobs[1].jobID, jobs[1].reason)
+	}
+}
@@ -0,0 +1,270 @@
+// Copyright 2020 Ohio Supercomputer Center
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	configNamespacesKey = "namespaces"
+)
+
+var (
+	// configRestartFlags only take effect when the job-pod-reaper starts
+	configRestartFlags = []string{
+		"run-once", "watch", "leader-elect", "leader-elect-namespace", "leader-elect-lease-name",
+		"leader-elect-lease-duration", "leader-elect-renew-deadline", "leader-elect-retry-period",
+		"reaper-policies", "listen-address", "kubeconfig", "kube-api-qps", "kube-api-burst", "log-level", "log-format",
+		"webhook", "webhook-listen-address", "webhook-tls-cert-file", "webhook-tls-key-file", "webhook-self-signed",
+		"webhook-service-name", "webhook-service-namespace", "webhook-configuration-name", "webhook-ca-secret-name",
+	}
+	// configWatchRestartFlags only take effect when the job-pod-reaper starts with --watch
+	// since the pod informers and their resync are created at startup
+	configWatchRestartFlags = []string{"reap-namespaces", "namespace-labels", "pods-labels", "reap-interval"}
+	// configWebhookRestartFlags only take effect when the job-pod-reaper starts with --webhook
+	// since the refresh of the webhook is scheduled at startup
+	configWebhookRestartFlags = []string{"reap-interval"}
+	// configIgnoredFlags can not be set from the configuration file
+	configIgnoredFlags = []string{"help", "config-file"}
+	configState        = &configLoader{}
+)
+
+// configRestartRequired returns whether a config key only takes effect when the job-pod-reaper starts
+func configRestartRequired(name string) bool {
+	return sliceContains(configRestartFlags, name) || (*watch && sliceContains(configWatchRestartFlags, name)) ||
+		(*webhook && sliceContains(configWebhookRestartFlags, name))
+}
+
+// reaperConfig is a parsed configuration file, flags holds flag values keyed by flag name
+type reaperConfig struct {
+	flags      map[string]string
+	namespaces map[string]namespacePolicy
+}
+
+// namespaceConfig are the per-namespace overrides of a configuration file
+type namespaceConfig struct {
+	DefaultLifetime string `json:"default-lifetime"`
+	MaxLifetime     string `json:"max-lifetime"`
+	ReapEvictedPods *bool  `json:"reap-evicted-pods"`
+}
+
+type configLoader struct {
+	checksum [sha256.Size]byte
+	// base holds the flag values from the command line and environment
+	base map[string]string
+}
+
+// initConfig loads --config-file when the job-pod-reaper starts
+func initConfig(logger log.Logger) error {
+	if *configFile == "" {
+		return nil
+	}
+	configState.base = flagValues()
+	return configState.load(logger, true)
+}
+
+// reloadConfig loads --config-file if it has changed since it was last loaded,
+// an invalid configuration is logged and the previous configuration is kept
+func reloadConfig(logger log.Logger) {
+	if *configFile == "" {
+		return
+	}
+	if err := configState.load(logger, false); err != nil {
+		level.Error(logger).Log("msg", "Error reloading config file, keeping previous configuration", "file", *configFile, "err", err)
+	}
+}
+
+func (c *configLoader) load(logger log.Logger, initial bool) error {
+	data, err := ioutil.ReadFile(*configFile)
+	if err != nil {
+		return c.failed(err)
+	}
+	checksum := sha256.Sum256(data)
+	if !initial && checksum == c.checksum {
+		return nil
+	}
+	cfg, err := parseConfig(data)
+	if err != nil {
+		c.checksum = checksum
+		return c.failed(err)
+	}
+	if err := applyConfig(cfg, c.base, initial, logger); err != nil {
+		c.checksum = checksum
+		return c.failed(err)
+	}
+	c.checksum = checksum
+	metricConfigLastReloadSuccess.Set(1)
+	if !initial {
+		level.Info(logger).Log("msg", "Reloaded config file", "file", *configFile)
+	}
+	return nil
+}
+
+func (c *configLoader) failed(err error) error {
+	metricConfigLastReloadSuccess.Set(0)
+	metricConfigReloadFailures.Inc()
+	return err
+}
+
+// parseConfig parses a YAML configuration file whose keys are flag names
+// and whose namespaces key holds per-namespace overrides
+func parseConfig(data []byte) (reaperConfig, error) {
+	cfg := reaperConfig{
+		flags:      make(map[string]string),
+		namespaces: make(map[string]namespacePolicy),
+	}
+	values := make(map[string]interface{})
+	if err := yaml.Unmarshal(data, &values); err != nil {
+		return cfg, fmt.Errorf("unable to parse config file: %v", err)
+	}
+	flags := make(map[string]bool)
+	for _, flag := range kingpin.CommandLine.Model().Flags {
+		flags[flag.Name] = true
+	}
+	for key, value := range values {
+		if key == configNamespacesKey {
+			namespaces, err := parseConfigNamespaces(value)
+			if err != nil {
+				return cfg, err
+			}
+			cfg.namespaces = namespaces
+			continue
+		}
+		if !flags[key] || sliceContains(configIgnoredFlags, key) {
+			return cfg, fmt.Errorf("unknown config key %s", key)
+		}
+		switch v := value.(type) {
+		case nil:
+			return cfg, fmt.Errorf("config key %s has no value", key)
+		case []interface{}:
+			items := []string{}
+			for _, item := range v {
+				items = append(items, configValue(item))
+			}
+			cfg.flags[key] = strings.Join(items, ",")
+		case map[string]interface{}:
+			return cfg, fmt.Errorf("config key %s must not be a map", key)
+		default:
+			cfg.flags[key] = configValue(v)
+		}
+	}
+	return cfg, nil
+}
+
+// configValue formats a YAML value as a flag value, YAML numbers are decoded
+// as float64 and are formatted without an exponent so Int flags can parse them
+func configValue(value interface{}) string {
+	if f, ok := value.(float64); ok {
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	return fmt.Sprint(value)
+}
+
+func parseConfigNamespaces(value interface{}) (map[string]namespacePolicy, error) {
+	policies := make(map[string]namespacePolicy)
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	namespaces := make(map[string]namespaceConfig)
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&namespaces); err != nil {
+		return nil, fmt.Errorf("unable to parse config namespaces: %v", err)
+	}
+	for namespace, nsConfig := range namespaces {
+		policy := namespacePolicy{reapEvictedPods: nsConfig.ReapEvictedPods}
+		if nsConfig.DefaultLifetime != "" {
+			if policy.defaultLifetime, err = parseLifetime(nsConfig.DefaultLifetime); err != nil {
+				return nil, fmt.Errorf("namespace %s default-lifetime: %v", namespace, err)
+			}
+		}
+		if nsConfig.MaxLifetime != "" {
+			if policy.maxLifetime, err = parseLifetime(nsConfig.MaxLifetime); err != nil {
+				return nil, fmt.Errorf("namespace %s max-lifetime: %v", namespace, err)
+			}
+		}
+		policies[namespace] = policy
+	}
+	return policies, nil
+}
+
+// applyConfig sets flags to their command line value overridden by the configuration,
+// the previous flag values are restored if the resulting configuration is invalid
+func applyConfig(cfg reaperConfig, base map[string]string, initial bool, logger log.Logger) error {
+	previous := flagValues()
+	for _, flag := range configFlags() {
+		value, ok := cfg.flags[flag.Name]
+		if !ok {
+			value = base[flag.Name]
+		}
+		if !initial && configRestartRequired(flag.Name) {
+			if value != previous[flag.Name] {
+				level.Warn(logger).Log("msg", "Config key requires a restart to take effect, ignoring", "key", flag.Name)
+			}
+			continue
+		}
+		if err := flag.Value.Set(value); err != nil {
+			restoreFlags(previous)
+			return fmt.Errorf("invalid value %q for config key %s: %v", value, flag.Name, err)
+		}
+	}
+	if err := validateFlags(); err != nil {
+		restoreFlags(previous)
+		return err
+	}
+	namespacePolicies.setOverrides(cfg.namespaces)
+	return nil
+}
+
+// configFlags returns the flags that can be set from the configuration file
+func configFlags() []*kingpin.FlagModel {
+	flags := []*kingpin.FlagModel{}
+	for _, flag := range kingpin.CommandLine.Model().Flags {
+		if sliceContains(configIgnoredFlags, flag.Name) {
+			continue
+		}
+		flags = append(flags, flag)
+	}
+	sort.Slice(flags, func(i, j int) bool { return flags[i].Name < flags[j].Name })
+	return flags
+}
+
+func flagValues() map[string]string {
+	values := make(map[string]string)
+	for _, flag := range configFlags() {
+		values[flag.Name] = flag.Value.String()
+	}
+	return values
+}
+
+func restoreFlags(values map[string]string) {
+	for _, flag := range configFlags() {
+		//nolint:errcheck
+		flag.Value.Set(values[flag.Name])
+	}
+}
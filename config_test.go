@@ -0,0 +1,179 @@
+// Copyright 2020 Ohio Supercomputer Center
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+)
+
+func TestParseConfig(t *testing.T) {
+	cfg, err := parseConfig([]byte(`
+reap-max: 10
+reap-interval: 5m
+reap-evicted-pods: false
+list-page-size: 1000000
+kube-api-qps: 7.5
+pods-labels:
+  - app.kubernetes.io/managed-by=open-ondemand
+  - app=jupyter
+namespaces:
+  user-user1:
+    default-lifetime: 8h
+    max-lifetime: 1d
+    reap-evicted-pods: true
+`))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := map[string]string{
+		"reap-max":          "10",
+		"reap-interval":     "5m",
+		"reap-evicted-pods": "false",
+		"list-page-size":    "1000000",
+		"kube-api-qps":      "7.5",
+		"pods-labels":       "app.kubernetes.io/managed-by=open-ondemand,app=jupyter",
+	}
+	for key, value := range expected {
+		if cfg.flags[key] != value {
+			t.Errorf("Unexpected value for %s, got: %q expected: %q", key, cfg.flags[key], value)
+		}
+	}
+	policy := cfg.namespaces["user-user1"]
+	if policy.defaultLifetime != 8*time.Hour || policy.maxLifetime != 24*time.Hour {
+		t.Errorf("Unexpected namespace lifetimes, got: %v %v", policy.defaultLifetime, policy.maxLifetime)
+	}
+	if policy.reapEvictedPods == nil || !*policy.reapEvictedPods {
+		t.Errorf("Unexpected namespace reap-evicted-pods, got: %v", policy.reapEvictedPods)
+	}
+}
+
+func TestParseConfigErrors(t *testing.T) {
+	tests := []string{
+		"foo: bar",
+		"config-file: /tmp/config.yaml",
+		"reap-max:",
+		"reap-max: {foo: bar}",
+		"namespaces: {user-user1: {foo: bar}}",
+		"namespaces: {user-user1: {max-lifetime: foo}}",
+		"- reap-max",
+	}
+	for _, data := range tests {
+		if _, err := parseConfig([]byte(data)); err == nil {
+			t.Errorf("Expected error parsing %q", data)
+		}
+	}
+}
+
+func TestReloadConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "job-pod-reaper")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config.yaml")
+	if err := ioutil.WriteFile(path, []byte("reap-max: 10\nreap-namespaces: user-user1\nlog-level: debug\nlist-page-size: 1000000\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := kingpin.CommandLine.Parse([]string{"--config-file=" + path, "--reap-max=5", "--reap-interval=2m"}); err != nil {
+		t.Fatal(err)
+	}
+	configState = &configLoader{}
+	defer namespacePolicies.setOverrides(nil)
+	logger := log.NewNopLogger()
+
+	if err := initConfig(logger); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if *reapMax != 10 || *reapNamespaces != "user-user1" || *logLevel != "debug" || *reapInterval != 2*time.Minute {
+		t.Errorf("Unexpected initial config, got: %d %s %s %v", *reapMax, *reapNamespaces, *logLevel, *reapInterval)
+	}
+	if *listPageSize != 1000000 {
+		t.Errorf("Unexpected list-page-size, got: %d", *listPageSize)
+	}
+
+	if err := ioutil.WriteFile(path, []byte("log-level: error\nnamespaces: {user-user1: {default-lifetime: 1h}}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	reloadConfig(logger)
+	if *reapMax != 5 || *reapNamespaces != "all" {
+		t.Errorf("Expected removed keys to use flag values, got: %d %s", *reapMax, *reapNamespaces)
+	}
+	if *logLevel != "debug" {
+		t.Errorf("Expected log-level to require restart, got: %s", *logLevel)
+	}
+	if policy := namespacePolicies.getOverrides()["user-user1"]; policy.defaultLifetime != time.Hour {
+		t.Errorf("Unexpected namespace override, got: %v", policy.defaultLifetime)
+	}
+
+	failures := testutil.ToFloat64(metricConfigReloadFailures)
+	if err := ioutil.WriteFile(path, []byte("reap-max: 20\nreap-timestamp: foo\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	reloadConfig(logger)
+	if *reapMax != 5 || *reapTimestamp != "start" {
+		t.Errorf("Expected invalid config to keep previous config, got: %d %s", *reapMax, *reapTimestamp)
+	}
+	if val := testutil.ToFloat64(metricConfigReloadFailures); val != failures+1 {
+		t.Errorf("Unexpected reload failures, got: %v", val)
+	}
+	if val := testutil.ToFloat64(metricConfigLastReloadSuccess); val != 0 {
+		t.Errorf("Unexpected last reload successful, got: %v", val)
+	}
+
+	// The watched namespaces and labels are fixed when using --watch
+	if err := ioutil.WriteFile(path, []byte("reap-namespaces: user-user1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := kingpin.CommandLine.Parse([]string{"--config-file=" + path, "--watch"}); err != nil {
+		t.Fatal(err)
+	}
+	configState = &configLoader{}
+	if err := initConfig(logger); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := ioutil.WriteFile(path, []byte("reap-namespaces: user-user2\npods-labels: app=test\nreap-interval: 5m\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	reloadConfig(logger)
+	if *reapNamespaces != "user-user1" || *podsLabels == "app=test" || *reapInterval != time.Minute {
+		t.Errorf("Expected watched namespaces, labels and interval to require restart, got: %s %s %v", *reapNamespaces, *podsLabels, *reapInterval)
+	}
+
+	// The webhook refresh interval is fixed when using --webhook
+	if err := ioutil.WriteFile(path, []byte("reap-max: 10\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := kingpin.CommandLine.Parse([]string{"--config-file=" + path, "--webhook", "--webhook-self-signed"}); err != nil {
+		t.Fatal(err)
+	}
+	configState = &configLoader{}
+	if err := initConfig(logger); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := ioutil.WriteFile(path, []byte("reap-max: 20\nreap-interval: 5m\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	reloadConfig(logger)
+	if *reapMax != 20 || *reapInterval != time.Minute {
+		t.Errorf("Expected webhook interval to require restart, got: %d %v", *reapMax, *reapInterval)
+	}
+}
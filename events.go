@@ -0,0 +1,86 @@
+// Copyright 2020 Ohio Supercomputer Center
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+)
+
+var (
+	eventsRecorded int64
+	eventsWritten  int64
+	// eventFlushTimeout bounds the wait for events to be written before exiting
+	eventFlushTimeout = 5 * time.Second
+)
+
+// countingRecorder counts the events recorded so they can be flushed before exiting
+type countingRecorder struct {
+	record.EventRecorder
+}
+
+func (r *countingRecorder) Event(object runtime.Object, eventtype, reason, message string) {
+	atomic.AddInt64(&eventsRecorded, 1)
+	r.EventRecorder.Event(object, eventtype, reason, message)
+}
+
+func (r *countingRecorder) Eventf(object runtime.Object, eventtype, reason, messageFmt string, args ...interface{}) {
+	atomic.AddInt64(&eventsRecorded, 1)
+	r.EventRecorder.Eventf(object, eventtype, reason, messageFmt, args...)
+}
+
+func (r *countingRecorder) AnnotatedEventf(object runtime.Object, annotations map[string]string, eventtype, reason, messageFmt string, args ...interface{}) {
+	atomic.AddInt64(&eventsRecorded, 1)
+	r.EventRecorder.AnnotatedEventf(object, annotations, eventtype, reason, messageFmt, args...)
+}
+
+// countingSink counts the events the event broadcaster writes to the API
+type countingSink struct {
+	record.EventSink
+}
+
+func (s *countingSink) Create(event *v1.Event) (*v1.Event, error) {
+	defer atomic.AddInt64(&eventsWritten, 1)
+	return s.EventSink.Create(event)
+}
+
+func (s *countingSink) Update(event *v1.Event) (*v1.Event, error) {
+	defer atomic.AddInt64(&eventsWritten, 1)
+	return s.EventSink.Update(event)
+}
+
+func (s *countingSink) Patch(oldEvent *v1.Event, data []byte) (*v1.Event, error) {
+	defer atomic.AddInt64(&eventsWritten, 1)
+	return s.EventSink.Patch(oldEvent, data)
+}
+
+// flushEvents waits until the recorded events have been written or the timeout is reached,
+// events dropped by the event correlator are never written so the wait is bounded
+func flushEvents(timeout time.Duration, logger log.Logger) {
+	deadline := time.Now().Add(timeout)
+	for atomic.LoadInt64(&eventsWritten) < atomic.LoadInt64(&eventsRecorded) {
+		if time.Now().After(deadline) {
+			level.Warn(logger).Log("msg", "Timed out waiting for events to be written", "recorded", atomic.LoadInt64(&eventsRecorded),
+				"written", atomic.LoadInt64(&eventsWritten))
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
@@ -0,0 +1,47 @@
+// Copyright 2020 Ohio Supercomputer Center
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestFlushEvents(t *testing.T) {
+	atomic.StoreInt64(&eventsRecorded, 0)
+	atomic.StoreInt64(&eventsWritten, 0)
+	r := &countingRecorder{record.NewFakeRecorder(10)}
+	r.Event(&v1.Pod{}, v1.EventTypeNormal, eventReasonReaped, "Reaped pod")
+	r.Eventf(&v1.Pod{}, v1.EventTypeNormal, eventReasonReaped, "Reaped %s", "pod")
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		atomic.AddInt64(&eventsWritten, 2)
+	}()
+	start := time.Now()
+	flushEvents(10*time.Second, log.NewNopLogger())
+	if atomic.LoadInt64(&eventsWritten) != 2 || time.Since(start) > 5*time.Second {
+		t.Errorf("Expected flush to wait for events to be written")
+	}
+	r.Event(&v1.Pod{}, v1.EventTypeNormal, eventReasonReaped, "Reaped pod")
+	start = time.Now()
+	flushEvents(200*time.Millisecond, log.NewNopLogger())
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond || elapsed > 5*time.Second {
+		t.Errorf("Expected flush to wait until the timeout, got: %v", elapsed)
+	}
+}
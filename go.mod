@@ -5,6 +5,7 @@ go 1.15
 require (
 	github.com/go-kit/kit v0.10.0
 	github.com/imdario/mergo v0.3.11 // indirect
+	github.com/prometheus/client_golang v1.7.1
 	golang.org/x/crypto v0.0.0-20201012173705-84dcc777aaee // indirect
 	golang.org/x/time v0.0.0-20201208040808-7e3f01d25324 // indirect
 	google.golang.org/appengine v1.6.6 // indirect
@@ -13,4 +14,5 @@ require (
 	k8s.io/apimachinery v0.19.5
 	k8s.io/client-go v0.19.5
 	k8s.io/utils v0.0.0-20201110183641-67b214c5f920 // indirect
+	sigs.k8s.io/yaml v1.2.0
 )
@@ -0,0 +1,88 @@
+// Copyright 2020 Ohio Supercomputer Center
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	day  = 24 * time.Hour
+	week = 7 * day
+)
+
+var (
+	lifetimeDaysRegexp = regexp.MustCompile(`^(?:([0-9]+(?:\.[0-9]+)?)w)?(?:([0-9]+(?:\.[0-9]+)?)d)?(.*)$`)
+	lifetimeISORegexp  = regexp.MustCompile(`^P(?:([0-9]+(?:\.[0-9]+)?)Y)?(?:([0-9]+(?:\.[0-9]+)?)M)?(?:([0-9]+(?:\.[0-9]+)?)W)?(?:([0-9]+(?:\.[0-9]+)?)D)?(?:T(?:([0-9]+(?:\.[0-9]+)?)H)?(?:([0-9]+(?:\.[0-9]+)?)M)?(?:([0-9]+(?:\.[0-9]+)?)S)?)?$`)
+)
+
+// parseLifetime parses a lifetime given as a Go duration that may also use
+// d (day) and w (week) units, such as 1w2d12h, or as an ISO 8601 duration such as P1DT12H
+func parseLifetime(value string) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, fmt.Errorf("empty lifetime")
+	}
+	if strings.HasPrefix(strings.ToUpper(value), "P") {
+		return parseISO8601Duration(strings.ToUpper(value))
+	}
+	matches := lifetimeDaysRegexp.FindStringSubmatch(value)
+	if matches == nil {
+		return 0, fmt.Errorf("invalid lifetime %q", value)
+	}
+	var lifetime time.Duration
+	lifetime += durationUnits(matches[1], week)
+	lifetime += durationUnits(matches[2], day)
+	if rest := matches[3]; rest != "" {
+		d, err := time.ParseDuration(rest)
+		if err != nil {
+			return 0, fmt.Errorf("invalid lifetime %q: %v", value, err)
+		}
+		lifetime += d
+	}
+	return lifetime, nil
+}
+
+func parseISO8601Duration(value string) (time.Duration, error) {
+	matches := lifetimeISORegexp.FindStringSubmatch(value)
+	if matches == nil || value == "P" || strings.HasSuffix(value, "T") {
+		return 0, fmt.Errorf("invalid ISO 8601 duration %q", value)
+	}
+	if matches[1] != "" || matches[2] != "" {
+		return 0, fmt.Errorf("invalid ISO 8601 duration %q: years and months are not supported", value)
+	}
+	var lifetime time.Duration
+	lifetime += durationUnits(matches[3], week)
+	lifetime += durationUnits(matches[4], day)
+	lifetime += durationUnits(matches[5], time.Hour)
+	lifetime += durationUnits(matches[6], time.Minute)
+	lifetime += durationUnits(matches[7], time.Second)
+	return lifetime, nil
+}
+
+// durationUnits returns the duration of value units, value having been matched as a decimal number
+func durationUnits(value string, unit time.Duration) time.Duration {
+	if value == "" {
+		return 0
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0
+	}
+	return time.Duration(f * float64(unit))
+}
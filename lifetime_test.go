@@ -0,0 +1,55 @@
+// Copyright 2020 Ohio Supercomputer Center
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseLifetime(t *testing.T) {
+	tests := map[string]time.Duration{
+		"30m":     30 * time.Minute,
+		"2h45m":   2*time.Hour + 45*time.Minute,
+		"7d":      7 * 24 * time.Hour,
+		"1.5d":    36 * time.Hour,
+		"1d12h":   36 * time.Hour,
+		"2w":      14 * 24 * time.Hour,
+		"1w1d1h":  8*24*time.Hour + time.Hour,
+		"P1DT12H": 36 * time.Hour,
+		"PT30M":   30 * time.Minute,
+		"P1W":     7 * 24 * time.Hour,
+		"p2d":     48 * time.Hour,
+		"PT1H30S": time.Hour + 30*time.Second,
+	}
+	for value, expected := range tests {
+		lifetime, err := parseLifetime(value)
+		if err != nil {
+			t.Errorf("Unexpected error parsing %s: %v", value, err)
+			continue
+		}
+		if lifetime != expected {
+			t.Errorf("Unexpected lifetime for %s, got: %v expected: %v", value, lifetime, expected)
+		}
+	}
+}
+
+func TestParseLifetimeErrors(t *testing.T) {
+	tests := []string{"", "foo", "1x", "d", "7dd", "P", "PT", "P1Y", "P1M", "P1DT", "1h7d"}
+	for _, value := range tests {
+		if lifetime, err := parseLifetime(value); err == nil {
+			t.Errorf("Expected error parsing %q, got: %v", value, lifetime)
+		}
+	}
+}
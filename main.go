@@ -15,53 +15,234 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"math"
 	"os"
+	"os/signal"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
 	kingpin "gopkg.in/alecthomas/kingpin.v2"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"k8s.io/client-go/tools/record"
 )
 
 const (
-	lifetimeAnnotation string = "pod.kubernetes.io/lifetime"
+	lifetimeAnnotation          string = "pod.kubernetes.io/lifetime"
+	reapAtAnnotation            string = "pod.kubernetes.io/reap-at"
+	lifetimeExtensionAnnotation string = "pod.kubernetes.io/lifetime-extension"
+	expiresAtAnnotation         string = "pod.kubernetes.io/expires-at"
+
+	reasonLifetime      string = "lifetime"
+	reasonEvicted       string = "evicted"
+	reasonExpiresAt     string = "expires-at"
+	reasonOrphaned      string = "orphaned"
+	dryRunNone          string = "none"
+	dryRunClient        string = "client"
+	dryRunServer        string = "server"
+	eventReasonReaped   string = "Reaped"
+	eventReasonEvicted  string = "EvictedCleanup"
+	eventReasonExpiring string = "ExpiringSoon"
+	eventReasonInvalid  string = "InvalidAnnotation"
+
+	reapStrategyFirst         string = "first"
+	reapStrategyRoundRobin    string = "round-robin"
+	reapStrategyOldestOverdue string = "oldest-overdue"
 )
 
 var (
 	runOnce = kingpin.Flag("run-once",
 		"Whether to run in loop (true) or run once like via cron (false)").Default("false").Envar("RUN_ONCE").Bool()
 	reapMax = kingpin.Flag("reap-max",
-		"Maximum Pods to reap in each run, set to 0 to disable this limit").Default("30").Envar("REAP_MAX").Int()
+		"Maximum jobs to reap in each run, set to 0 to disable this limit").Default("30").Envar("REAP_MAX").Int()
+	reapMaxObjects = kingpin.Flag("reap-max-objects",
+		"Maximum objects, including pods, to delete in each run, set to 0 to disable this limit").Default("0").Envar("REAP_MAX_OBJECTS").Int()
 	reapEvictedPods = kingpin.Flag("reap-evicted-pods",
 		"Whether or not to delete evicted pods").Default("true").Envar("REAP_EVICTED_PODS").Bool()
+	reapPendingAfter = kingpin.Flag("reap-pending-after",
+		"Reap pods that have been Pending for longer than this duration, set to 0 to disable").Default("0s").Envar("REAP_PENDING_AFTER").Duration()
+	reapUnschedulableAfter = kingpin.Flag("reap-unschedulable-after",
+		"Reap pods that have been unschedulable for longer than this duration, set to 0 to disable").Default("0s").Envar("REAP_UNSCHEDULABLE_AFTER").Duration()
+	reapImagePullFailingAfter = kingpin.Flag("reap-image-pull-failing-after",
+		"Reap pods with a container that has been failing to pull its image for longer than this duration, set to 0 to disable").Default("0s").Envar("REAP_IMAGE_PULL_FAILING_AFTER").Duration()
+	reapCrashLoopRestarts = kingpin.Flag("reap-crashloop-restarts",
+		"Reap pods in CrashLoopBackOff with a container restarted more than this number of times, set to 0 to disable").Default("0").Envar("REAP_CRASHLOOP_RESTARTS").Int()
+	reapPVCs = kingpin.Flag("reap-persistentvolumeclaims",
+		"Reap PersistentVolumeClaims with the job label").Default("false").Envar("REAP_PERSISTENTVOLUMECLAIMS").Bool()
+	reapIngresses = kingpin.Flag("reap-ingresses",
+		"Reap Ingresses with the job label").Default("false").Envar("REAP_INGRESSES").Bool()
+	reapNetworkPolicies = kingpin.Flag("reap-networkpolicies",
+		"Reap NetworkPolicies with the job label").Default("false").Envar("REAP_NETWORKPOLICIES").Bool()
+	reapServiceAccounts = kingpin.Flag("reap-serviceaccounts",
+		"Reap ServiceAccounts with the job label").Default("false").Envar("REAP_SERVICEACCOUNTS").Bool()
+	reapRoleBindings = kingpin.Flag("reap-rolebindings",
+		"Reap RoleBindings with the job label").Default("false").Envar("REAP_ROLEBINDINGS").Bool()
+	reapEndpoints = kingpin.Flag("reap-endpoints",
+		"Reap Endpoints with the job label").Default("false").Envar("REAP_ENDPOINTS").Bool()
+	reapOwners = kingpin.Flag("reap-owners",
+		"Delete the top-level Job, Deployment, StatefulSet, ReplicaSet or Argo Workflow controlling a pod instead of the pod").Default("false").Envar("REAP_OWNERS").Bool()
+	maxLifetime = kingpin.Flag("max-lifetime",
+		"Maximum pod lifetime including lifetime extensions, set to 0 to disable this limit").Default("0s").Envar("MAX_LIFETIME").Duration()
+	maxExtensions = kingpin.Flag("max-extensions",
+		"Maximum number of lifetime extensions honored for a pod, set to 0 to disable this limit").Default("0").Envar("MAX_EXTENSIONS").Int()
+	warningWindow = kingpin.Flag("warning-window",
+		"Duration before a pod's lifetime elapses to annotate and warn that it will be reaped, set to 0 to disable").Default("0s").Envar("WARNING_WINDOW").Duration()
+	reaperPoliciesEnabled = kingpin.Flag("reaper-policies",
+		"Evaluate pods against ReaperPolicy and ClusterReaperPolicy resources").Default("false").Envar("REAPER_POLICIES").Bool()
+	watch = kingpin.Flag("watch",
+		"Watch pods with informers and reap each pod at its expiry time instead of listing pods each interval").Default("false").Envar("WATCH").Bool()
+	leaderElect = kingpin.Flag("leader-elect",
+		"Use leader election so only one of multiple replicas reaps at a time").Default("false").Envar("LEADER_ELECT").Bool()
+	leaderElectNamespace = kingpin.Flag("leader-elect-namespace",
+		"Namespace of the leader election Lease").Default("job-pod-reaper").Envar("LEADER_ELECT_NAMESPACE").String()
+	leaderElectLeaseName = kingpin.Flag("leader-elect-lease-name",
+		"Name of the leader election Lease").Default("job-pod-reaper").Envar("LEADER_ELECT_LEASE_NAME").String()
+	leaderElectLeaseDuration = kingpin.Flag("leader-elect-lease-duration",
+		"Duration standby replicas wait before attempting to acquire the lease").Default("15s").Envar("LEADER_ELECT_LEASE_DURATION").Duration()
+	leaderElectRenewDeadline = kingpin.Flag("leader-elect-renew-deadline",
+		"Duration the leader retries refreshing the lease before giving up").Default("10s").Envar("LEADER_ELECT_RENEW_DEADLINE").Duration()
+	leaderElectRetryPeriod = kingpin.Flag("leader-elect-retry-period",
+		"Duration between leader election attempts").Default("2s").Envar("LEADER_ELECT_RETRY_PERIOD").Duration()
+	webhook = kingpin.Flag("webhook",
+		"Serve admission webhooks instead of reaping").Default("false").Envar("WEBHOOK").Bool()
+	webhookListenAddress = kingpin.Flag("webhook-listen-address",
+		"Address to listen on for admission webhook requests").Default(":8443").Envar("WEBHOOK_LISTEN_ADDRESS").String()
+	webhookCertFile = kingpin.Flag("webhook-tls-cert-file",
+		"Path to the webhook TLS certificate").Default("").Envar("WEBHOOK_TLS_CERT_FILE").String()
+	webhookKeyFile = kingpin.Flag("webhook-tls-key-file",
+		"Path to the webhook TLS private key").Default("").Envar("WEBHOOK_TLS_KEY_FILE").String()
+	webhookSelfSigned = kingpin.Flag("webhook-self-signed",
+		"Generate a self-signed webhook certificate and set the CA bundle of the webhook configuration").Default("false").Envar("WEBHOOK_SELF_SIGNED").Bool()
+	webhookServiceName = kingpin.Flag("webhook-service-name",
+		"Name of the webhook Service used for the self-signed certificate").Default("job-pod-reaper-webhook").Envar("WEBHOOK_SERVICE_NAME").String()
+	webhookServiceNamespace = kingpin.Flag("webhook-service-namespace",
+		"Namespace of the webhook Service used for the self-signed certificate").Default("job-pod-reaper").Envar("WEBHOOK_SERVICE_NAMESPACE").String()
+	webhookConfigName = kingpin.Flag("webhook-configuration-name",
+		"Name of the webhook configuration updated with the self-signed CA bundle").Default("job-pod-reaper").Envar("WEBHOOK_CONFIGURATION_NAME").String()
+	webhookCASecretName = kingpin.Flag("webhook-ca-secret-name",
+		"Name of the Secret in the webhook Service namespace that stores the self-signed CA shared by all replicas").Default("job-pod-reaper-webhook-ca").Envar("WEBHOOK_CA_SECRET_NAME").String()
+	webhookAction = kingpin.Flag("webhook-action",
+		"Action for pods with an invalid lifetime, One of: [deny, warn]").Default(webhookActionDeny).Envar("WEBHOOK_ACTION").String()
+	webhookRequireLifetime = kingpin.Flag("webhook-require-lifetime-namespaces",
+		"Comma separated list of namespaces where the webhook requires pods to have a lifetime").Default("").Envar("WEBHOOK_REQUIRE_LIFETIME_NAMESPACES").String()
+	webhookMutateLabels = kingpin.Flag("webhook-mutate-labels",
+		"Comma separated list of labels of pods the mutating webhook adds a lifetime and job label to").Default("").Envar("WEBHOOK_MUTATE_LABELS").String()
+	webhookDefaultLifetime = kingpin.Flag("webhook-default-lifetime",
+		"Lifetime the mutating webhook adds to pods in namespaces without a default lifetime, set to 0 to only use namespace default lifetimes").Default("0s").Envar("WEBHOOK_DEFAULT_LIFETIME").Duration()
 	reapInterval       = kingpin.Flag("reap-interval", "Duration between repear runs").Default("60s").Envar("REAP_INTERLVAL").Duration()
 	reapNamespaces     = kingpin.Flag("reap-namespaces", "Namespaces to reap").Default("all").Envar("REAP_NAMESPACES").String()
 	reapTimestamp      = kingpin.Flag("reap-timestamp", "The Pod timestamp evaluate for reaping, One of: [start, creation]").Default("start").Envar("REAP_TIMESTAMP").String()
 	reapTimestampValid = []string{"start", "creation"}
 	namespaceLabels    = kingpin.Flag("namespace-labels", "Labels to use when filtering namespaces").Default("").Envar("NAMESPACE_LABELS").String()
 	podsLabels         = kingpin.Flag("pods-labels", "Labels to use when filtering pods").Default("").Envar("PODS_LABELS").String()
+	dryRun             = kingpin.Flag("dry-run", "Report what would be reaped without deleting, One of: [none, client, server]").Default(dryRunNone).Envar("DRY_RUN").String()
+	dryRunValid        = []string{dryRunNone, dryRunClient, dryRunServer}
+	reapStrategy       = kingpin.Flag("reap-strategy", "How jobs are selected when more than --reap-max can be reaped, One of: [first, round-robin, oldest-overdue]").Default(reapStrategyFirst).Envar("REAP_STRATEGY").String()
+	reapStrategyValid  = []string{reapStrategyFirst, reapStrategyRoundRobin, reapStrategyOldestOverdue}
+	reapWorkers        = kingpin.Flag("reap-workers", "Number of jobs to delete concurrently").Default("1").Envar("REAP_WORKERS").Int()
+	apiRetries         = kingpin.Flag("api-retries", "Number of times to retry API calls that fail with a transient error").Default("3").Envar("API_RETRIES").Int()
+	apiRetryBackoff    = kingpin.Flag("api-retry-backoff", "Initial backoff between retries of API calls, doubled after each retry").Default("1s").Envar("API_RETRY_BACKOFF").Duration()
+	listPageSize       = kingpin.Flag("list-page-size", "Number of objects to request in each List call, set to 0 to disable paging").Default("500").Envar("LIST_PAGE_SIZE").Int()
+	propagationPolicy  = kingpin.Flag("propagation-policy", "Propagation policy when deleting the controller of a pod, One of: [Background, Foreground, Orphan]").Default(string(metav1.DeletePropagationBackground)).Envar("PROPAGATION_POLICY").String()
+	orphanGracePeriod  = kingpin.Flag("orphan-grace-period", "Duration objects with the job label are kept once their job has no pods before they are reaped, set to 0 to disable").Default("0s").Envar("ORPHAN_GRACE_PERIOD").Duration()
+	reapResources      = kingpin.Flag("reap-resources", "Comma separated list of group/version/resource of other objects with the job label to reap").Default("").Envar("REAP_RESOURCES").String()
 	jobLabel           = kingpin.Flag("job-label", "Label to associate pod job with other objects").Default("job").Envar("JOB_LABEL").String()
+	listenAddress      = kingpin.Flag("listen-address", "Address to listen on for metrics when running in loop").Default(":8080").Envar("LISTEN_ADDRESS").String()
+	configFile         = kingpin.Flag("config-file", "Path to YAML configuration file that is reloaded when changed").Default("").Envar("CONFIG_FILE").String()
 	kubeconfig         = kingpin.Flag("kubeconfig", "Path to kubeconfig when running outside Kubernetes cluster").Default("").Envar("KUBECONFIG").String()
+	kubeAPIQPS         = kingpin.Flag("kube-api-qps", "Maximum queries per second to the Kubernetes API").Default("5").Envar("KUBE_API_QPS").Float32()
+	kubeAPIBurst       = kingpin.Flag("kube-api-burst", "Maximum burst of queries to the Kubernetes API").Default("10").Envar("KUBE_API_BURST").Int()
 	logLevel           = kingpin.Flag("log-level", "Log level, One of: [debug, info, warn, error]").Default("info").Envar("LOG_LEVEL").String()
 	logFormat          = kingpin.Flag("log-format", "Log format, One of: [logfmt, json]").Default("logfmt").Envar("LOG_FORMAT").String()
 	timestampFormat    = log.TimestampFormat(
 		func() time.Time { return time.Now().UTC() },
 		"2006-01-02T15:04:05.000Z07:00",
 	)
-	timeNow = time.Now
+	timeNow     = time.Now
+	recorder    record.EventRecorder
+	objectKinds = map[string]objectKind{
+		"pod":                   {apiVersion: "v1", kind: "Pod", resource: "pods"},
+		"service":               {apiVersion: "v1", kind: "Service", resource: "services"},
+		"configmap":             {apiVersion: "v1", kind: "ConfigMap", resource: "configmaps"},
+		"secret":                {apiVersion: "v1", kind: "Secret", resource: "secrets"},
+		"persistentvolumeclaim": {apiVersion: "v1", kind: "PersistentVolumeClaim", resource: "persistentvolumeclaims"},
+		"ingress":               {apiVersion: "networking.k8s.io/v1", kind: "Ingress", resource: "ingresses"},
+		"networkpolicy":         {apiVersion: "networking.k8s.io/v1", kind: "NetworkPolicy", resource: "networkpolicies"},
+		"serviceaccount":        {apiVersion: "v1", kind: "ServiceAccount", resource: "serviceaccounts"},
+		"rolebinding":           {apiVersion: "rbac.authorization.k8s.io/v1", kind: "RoleBinding", resource: "rolebindings"},
+		"endpoints":             {apiVersion: "v1", kind: "Endpoints", resource: "endpoints"},
+		"job":                   {apiVersion: "batch/v1", kind: "Job", resource: "jobs"},
+		"deployment":            {apiVersion: "apps/v1", kind: "Deployment", resource: "deployments"},
+		"statefulset":           {apiVersion: "apps/v1", kind: "StatefulSet", resource: "statefulsets"},
+		"replicaset":            {apiVersion: "apps/v1", kind: "ReplicaSet", resource: "replicasets"},
+		"workflow":              {apiVersion: "argoproj.io/v1alpha1", kind: "Workflow", resource: "workflows"},
+	}
+	// relatedObjectTypes are the types of objects with the job label in the order they are reaped
+	relatedObjectTypes = []string{"service", "configmap", "secret", "persistentvolumeclaim", "ingress",
+		"networkpolicy", "serviceaccount", "rolebinding", "endpoints"}
+)
+
+type objectKind struct {
+	apiVersion string
+	kind       string
+	resource   string
+}
+
+// runBacklog counts jobs that could be reaped but were left for a later run by the reap limits,
+// runBacklogUnknown is set when listing stopped at --reap-max so the backlog was not counted
+var (
+	runBacklog        int64
+	runBacklogUnknown int32
 )
 
+// backlogSummary returns the backlog for the reap summary, unknown if listing stopped early
+func backlogSummary() interface{} {
+	if atomic.LoadInt32(&runBacklogUnknown) != 0 {
+		return "unknown"
+	}
+	return atomic.LoadInt64(&runBacklog)
+}
+
 type podJob struct {
 	jobID     string
 	podName   string
 	namespace string
+	reason    string
+	age       time.Duration
+	lifetime  time.Duration
+	overdue   time.Duration
+	policy    *reaperPolicy
+	owners    []metav1.OwnerReference
+}
+
+// key identifies the job of the pod, pods without a job label are their own job
+func (j podJob) key() string {
+	if j.jobID == "" {
+		return j.namespace + "/pod/" + j.podName
+	}
+	return j.namespace + "/" + j.jobID
 }
 
 type jobObject struct {
@@ -69,11 +250,19 @@ type jobObject struct {
 	jobID      string
 	name       string
 	namespace  string
+	reason     string
+	age        time.Duration
+	lifetime   time.Duration
+	policy     *reaperPolicy
+	resource   *reapResource
+	// owner is the top-level controller of a pod that is deleted instead of the pod
+	owner *jobObject
 }
 
 func main() {
 	kingpin.HelpFlag.Short('h')
 	kingpin.Parse()
+	configErr := initConfig(log.NewNopLogger())
 
 	var logger log.Logger
 	if *logFormat == "json" {
@@ -97,10 +286,17 @@ func main() {
 	}
 	logger = log.With(logger, "ts", timestampFormat, "caller", log.DefaultCaller)
 
-	if !sliceContains(reapTimestampValid, *reapTimestamp) {
-		level.Error(logger).Log("msg", "Unrecognized reap-timestamp", "value", *reapTimestamp)
+	if configErr != nil {
+		level.Error(logger).Log("msg", "Error loading config file", "file", *configFile, "err", configErr)
 		os.Exit(1)
 	}
+	if err := validateFlags(); err != nil {
+		level.Error(logger).Log("msg", "Invalid configuration", "err", err)
+		os.Exit(1)
+	}
+	if *dryRun != dryRunNone {
+		level.Info(logger).Log("msg", "Running in dry-run mode, no objects will be deleted", "dry_run", *dryRun)
+	}
 
 	var config *rest.Config
 	var err error
@@ -120,6 +316,8 @@ func main() {
 		level.Error(logger).Log("msg", "Error loading kubeconfig", "err", err)
 		os.Exit(1)
 	}
+	config.QPS = *kubeAPIQPS
+	config.Burst = *kubeAPIBurst
 
 	clientset, err := kubernetes.NewForConfig(config)
 	if err != nil {
@@ -127,41 +325,236 @@ func main() {
 		os.Exit(1)
 	}
 
+	dynamicClient, err = dynamic.NewForConfig(config)
+	if err != nil {
+		level.Error(logger).Log("msg", "Unable to generate dynamic client", "err", err)
+		os.Exit(1)
+	}
+
+	if *reaperPoliciesEnabled {
+		policyClient, err = dynamic.NewForConfig(config)
+		if err != nil {
+			level.Error(logger).Log("msg", "Unable to generate dynamic client", "err", err)
+			os.Exit(1)
+		}
+	}
+
+	eventBroadcaster := record.NewBroadcaster()
+	eventBroadcaster.StartRecordingToSink(&countingSink{&typedcorev1.EventSinkImpl{Interface: clientset.CoreV1().Events("")}})
+	defer eventBroadcaster.Shutdown()
+	defer flushEvents(eventFlushTimeout, logger)
+	recorder = &countingRecorder{eventBroadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: "job-pod-reaper"})}
+
+	if !*runOnce {
+		go metricsServer(logger)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go handleSignals(cancel, logger)
+
+	if *webhook {
+		runWebhook(ctx, clientset, logger)
+		return
+	}
+
+	loop := reapLoop
+	if *watch {
+		loop = watchLoop
+	}
+	if *leaderElect {
+		leaderElection(ctx, clientset, logger, func(ctx context.Context) {
+			loop(ctx, clientset, logger)
+			cancel()
+		})
+	} else {
+		loop(ctx, clientset, logger)
+	}
+}
+
+// handleSignals cancels the context on SIGTERM or SIGINT so the objects of the job
+// being reaped are deleted before exiting, a second signal exits immediately
+func handleSignals(cancel context.CancelFunc, logger log.Logger) {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	sig := <-signals
+	level.Info(logger).Log("msg", "Received signal, shutting down", "signal", sig)
+	cancel()
+	sig = <-signals
+	level.Error(logger).Log("msg", "Received second signal, exiting", "signal", sig)
+	os.Exit(1)
+}
+
+// validateFlags checks flag values that kingpin does not validate
+func validateFlags() error {
+	if !sliceContains(reapTimestampValid, *reapTimestamp) {
+		return fmt.Errorf("unrecognized reap-timestamp %s", *reapTimestamp)
+	}
+	if !sliceContains(dryRunValid, *dryRun) {
+		return fmt.Errorf("unrecognized dry-run %s", *dryRun)
+	}
+	if !sliceContains(reapStrategyValid, *reapStrategy) {
+		return fmt.Errorf("unrecognized reap-strategy %s", *reapStrategy)
+	}
+	if *watch && *runOnce {
+		return fmt.Errorf("the watch and run-once options can not be used together")
+	}
+	// Pods are only watched for --pods-labels so policy selectors would never be reaped
+	if *watch && *reaperPoliciesEnabled {
+		return fmt.Errorf("the watch and reaper-policies options can not be used together")
+	}
+	// Orphans are tracked in memory so a new process would never reach the grace period
+	if *runOnce && *orphanGracePeriod != 0 {
+		return fmt.Errorf("the run-once and orphan-grace-period options can not be used together")
+	}
+	if !sliceContains(webhookActionValid, *webhookAction) {
+		return fmt.Errorf("unrecognized webhook-action %s", *webhookAction)
+	}
+	if *reapWorkers < 1 {
+		return fmt.Errorf("reap-workers must be at least 1")
+	}
+	if !sliceContains(propagationValid, *propagationPolicy) {
+		return fmt.Errorf("unrecognized propagation-policy %s", *propagationPolicy)
+	}
+	if _, err := parseReapResources(*reapResources); err != nil {
+		return err
+	}
+	if *reapPendingAfter < 0 || *reapUnschedulableAfter < 0 || *reapImagePullFailingAfter < 0 || *reapCrashLoopRestarts < 0 {
+		return fmt.Errorf("reap-pending-after, reap-unschedulable-after, reap-image-pull-failing-after and reap-crashloop-restarts must not be negative")
+	}
+	if *apiRetries < 0 {
+		return fmt.Errorf("api-retries must not be negative")
+	}
+	if *kubeAPIQPS <= 0 || *kubeAPIBurst < 1 {
+		return fmt.Errorf("kube-api-qps and kube-api-burst must be greater than 0")
+	}
+	if *webhook && *runOnce {
+		return fmt.Errorf("the webhook and run-once options can not be used together")
+	}
+	if *webhook && !*webhookSelfSigned && (*webhookCertFile == "" || *webhookKeyFile == "") {
+		return fmt.Errorf("the webhook option requires webhook-self-signed or webhook-tls-cert-file and webhook-tls-key-file")
+	}
+	return nil
+}
+
+func reapLoop(ctx context.Context, clientset kubernetes.Interface, logger log.Logger) {
 	for {
-		run(clientset, logger)
-		if *runOnce {
+		run(ctx, clientset, logger)
+		if *runOnce || ctx.Err() != nil {
 			break
 		} else {
 			level.Debug(logger).Log("msg", "Sleeping...", "interval", fmt.Sprintf("%.0f", (*reapInterval).Seconds()))
-			time.Sleep(*reapInterval)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(*reapInterval):
+			}
 		}
 	}
 }
-func run(clientset kubernetes.Interface, logger log.Logger) {
-	namespaces, err := getNamespaces(clientset, logger)
+
+func leaderElection(ctx context.Context, clientset kubernetes.Interface, logger log.Logger, runFunc func(ctx context.Context)) {
+	identity, err := os.Hostname()
+	if err != nil {
+		level.Error(logger).Log("msg", "Unable to determine hostname for leader election identity", "err", err)
+		os.Exit(1)
+	}
+	electionLogger := log.With(logger, "lease", *leaderElectLeaseName, "namespace", *leaderElectNamespace, "identity", identity)
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta: metav1.ObjectMeta{
+			Name:      *leaderElectLeaseName,
+			Namespace: *leaderElectNamespace,
+		},
+		Client: clientset.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{
+			Identity: identity,
+		},
+	}
+	level.Info(electionLogger).Log("msg", "Waiting to acquire leader lease")
+	leaderelection.RunOrDie(ctx, leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		ReleaseOnCancel: true,
+		LeaseDuration:   *leaderElectLeaseDuration,
+		RenewDeadline:   *leaderElectRenewDeadline,
+		RetryPeriod:     *leaderElectRetryPeriod,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(ctx context.Context) {
+				level.Info(electionLogger).Log("msg", "Acquired leader lease")
+				metricLeader.Set(1)
+				runFunc(ctx)
+			},
+			OnStoppedLeading: func() {
+				level.Info(electionLogger).Log("msg", "Stopped leading")
+				metricLeader.Set(0)
+			},
+			OnNewLeader: func(leader string) {
+				if leader != identity {
+					level.Info(electionLogger).Log("msg", "New leader elected", "leader", leader)
+				}
+			},
+		},
+	})
+}
+
+func run(ctx context.Context, clientset kubernetes.Interface, logger log.Logger) {
+	reloadConfig(logger)
+	runFailures.reset()
+	atomic.StoreInt64(&runBacklog, 0)
+	atomic.StoreInt32(&runBacklogUnknown, 0)
+	timer := prometheus.NewTimer(metricDuration.WithLabelValues("getNamespaces"))
+	namespaces, err := getNamespaces(ctx, clientset, logger)
+	timer.ObserveDuration()
 	if err != nil {
 		level.Error(logger).Log("msg", "Error getting namespaces", "err", err)
 		return
 	}
-	jobs, err := getJobs(clientset, namespaces, logger)
+	loadReaperPolicies(ctx, logger)
+	timer = prometheus.NewTimer(metricDuration.WithLabelValues("getJobs"))
+	jobs, err := getJobs(ctx, clientset, namespaces, logger)
+	timer.ObserveDuration()
 	if err != nil {
 		level.Error(logger).Log("msg", "Error getting jods", "err", err)
 		return
 	}
-	jobObjects, err := getJobObjects(clientset, jobs, logger)
+	refreshReapResources(clientset.Discovery(), logger)
+	timer = prometheus.NewTimer(metricDuration.WithLabelValues("getJobObjects"))
+	jobObjects, err := getJobObjects(ctx, clientset, jobs, logger)
+	timer.ObserveDuration()
 	if err != nil {
-		level.Error(logger).Log("msg", "Error getting job objects", "err", err)
+		level.Warn(logger).Log("msg", "Skipping jobs whose objects could not be listed", "err", err)
+	}
+	jobObjects = limitJobObjects(jobObjects, *reapMaxObjects, logger)
+	if atomic.LoadInt32(&runBacklogUnknown) != 0 {
+		metricBacklog.Set(math.NaN())
+	} else {
+		metricBacklog.Set(float64(atomic.LoadInt64(&runBacklog)))
+	}
+	if ctx.Err() != nil {
+		level.Info(logger).Log("msg", "Shutting down, not reaping")
 		return
 	}
-	err = reap(clientset, jobObjects, logger)
+	timer = prometheus.NewTimer(metricDuration.WithLabelValues("reap"))
+	err = reap(ctx, clientset, jobObjects, logger)
+	timer.ObserveDuration()
 	if err != nil {
 		level.Error(logger).Log("msg", "Error reaping", "err", err)
 		return
 	}
+	if ctx.Err() == nil {
+		timer = prometheus.NewTimer(metricDuration.WithLabelValues("sweepOrphans"))
+		err = sweepOrphans(ctx, clientset, namespaces, jobObjects, logger)
+		timer.ObserveDuration()
+		if err != nil {
+			level.Error(logger).Log("msg", "Error sweeping orphaned objects", "err", err)
+		}
+	}
+	updateReaperPolicyStatus(ctx, logger)
+	metricLastSuccess.SetToCurrentTime()
 }
 
-func getNamespaces(clientset kubernetes.Interface, logger log.Logger) ([]string, error) {
+func getNamespaces(ctx context.Context, clientset kubernetes.Interface, logger log.Logger) ([]string, error) {
 	var namespaces []string
+	policies := namespacePolicies.getOverrides()
 	namespaces = strings.Split(*reapNamespaces, ",")
 	if len(namespaces) == 1 && strings.ToLower(namespaces[0]) == "all" {
 		namespaces = []string{metav1.NamespaceAll}
@@ -174,170 +567,839 @@ func getNamespaces(clientset kubernetes.Interface, logger log.Logger) ([]string,
 				LabelSelector: label,
 			}
 			level.Debug(logger).Log("msg", "Getting namespaces with label", "label", label)
-			ns, err := clientset.CoreV1().Namespaces().List(context.TODO(), nsListOptions)
+			err := listPages(ctx, logger, nsListOptions, func(options metav1.ListOptions) (string, bool, error) {
+				ns, err := clientset.CoreV1().Namespaces().List(ctx, options)
+				if err != nil {
+					return "", false, err
+				}
+				level.Debug(logger).Log("msg", "Namespaces returned", "count", len(ns.Items))
+				for _, namespace := range ns.Items {
+					namespaces = append(namespaces, namespace.Name)
+					if policy := getNamespacePolicy(&namespace, policies[namespace.Name], logger); !policy.empty() {
+						policies[namespace.Name] = policy
+					}
+				}
+				return ns.Continue, true, nil
+			})
 			if err != nil {
-				level.Error(logger).Log("msg", "Error getting namespace list", "label", label, "err", err)
+				runFailures.record("list", err)
+				level.Error(logger).Log("msg", errorMessage("Error getting namespace list", err), "label", label, "err", err)
 				return nil, err
 			}
-			level.Debug(logger).Log("msg", "Namespaces returned", "count", len(ns.Items))
+		}
+
+	} else {
+		err := listPages(ctx, logger, metav1.ListOptions{}, func(options metav1.ListOptions) (string, bool, error) {
+			ns, err := clientset.CoreV1().Namespaces().List(ctx, options)
+			if err != nil {
+				return "", false, err
+			}
 			for _, namespace := range ns.Items {
-				namespaces = append(namespaces, namespace.Name)
+				if namespaces[0] != metav1.NamespaceAll && !sliceContains(namespaces, namespace.Name) {
+					continue
+				}
+				if policy := getNamespacePolicy(&namespace, policies[namespace.Name], logger); !policy.empty() {
+					policies[namespace.Name] = policy
+				}
 			}
+			return ns.Continue, true, nil
+		})
+		if err != nil {
+			runFailures.record("list", err)
+			level.Warn(logger).Log("msg", errorMessage("Error getting namespaces for lifetime policies", err), "err", err)
 		}
-
 	}
+	namespacePolicies.set(policies)
 	return namespaces, nil
 }
 
-func getJobs(clientset kubernetes.Interface, namespaces []string, logger log.Logger) ([]podJob, error) {
-	labels := strings.Split(*podsLabels, ",")
+func getJobs(ctx context.Context, clientset kubernetes.Interface, namespaces []string, logger log.Logger) ([]podJob, error) {
 	jobs := []podJob{}
-	toReap := 0
-	for _, ns := range namespaces {
-		for _, l := range labels {
-			listOptions := metav1.ListOptions{
-				LabelSelector: l,
+	toReap := make(map[string]bool)
+	tracked := 0
+	stopped := false
+	defer func() {
+		// The tracked pods are left unchanged when listing stopped early so the gauge does not undercount
+		if !stopped {
+			metricTrackedPods.Set(float64(tracked))
+		}
+	}()
+	err := eachPod(ctx, clientset, namespaces, logger, func(pod *v1.Pod) bool {
+		podLogger := log.With(logger, "pod", pod.Name, "namespace", pod.Namespace)
+		if podTracked(pod) {
+			tracked++
+		}
+		countPolicyMatch(pod)
+		if job, ok := evaluatePod(ctx, clientset, pod, podLogger); ok {
+			jobs = append(jobs, job)
+			toReap[job.key()] = true
+		}
+		// Other strategies select from every pod that can be reaped once all pods are listed
+		if *reapStrategy == reapStrategyFirst && *reapMax != 0 && len(toReap) >= *reapMax {
+			level.Info(logger).Log("msg", "Max reap reached, skipping rest", "max", *reapMax)
+			atomic.StoreInt32(&runBacklogUnknown, 1)
+			stopped = true
+			return false
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	if *reapStrategy != reapStrategyFirst && *reapMax != 0 && len(toReap) > *reapMax {
+		backlog := len(toReap) - *reapMax
+		level.Info(logger).Log("msg", "Max reap reached, skipping rest", "max", *reapMax, "strategy", *reapStrategy, "skipped", backlog)
+		atomic.AddInt64(&runBacklog, int64(backlog))
+		jobs = selectJobs(jobs, *reapMax)
+	}
+	return jobs, nil
+}
+
+// selectJobs returns the pods of max jobs selected by --reap-strategy,
+// a job is as overdue as its most overdue pod
+func selectJobs(jobs []podJob, max int) []podJob {
+	keys := []string{}
+	pods := make(map[string][]podJob)
+	overdue := make(map[string]time.Duration)
+	for _, job := range jobs {
+		key := job.key()
+		if _, ok := pods[key]; !ok {
+			keys = append(keys, key)
+			overdue[key] = job.overdue
+		}
+		pods[key] = append(pods[key], job)
+		if job.overdue > overdue[key] {
+			overdue[key] = job.overdue
+		}
+	}
+	sort.SliceStable(keys, func(i, j int) bool {
+		return overdue[keys[i]] > overdue[keys[j]]
+	})
+	selected := keys
+	if len(keys) > max {
+		selected = keys[:max]
+	}
+	if *reapStrategy == reapStrategyRoundRobin {
+		selected = roundRobin(keys, pods, max)
+	}
+	selectedJobs := []podJob{}
+	for _, key := range selected {
+		selectedJobs = append(selectedJobs, pods[key]...)
+	}
+	return selectedJobs
+}
+
+// roundRobin takes the most overdue job of each namespace in turn, namespaces are
+// ordered by their most overdue job so a namespace skipped in one run is reached
+func roundRobin(keys []string, pods map[string][]podJob, max int) []string {
+	namespaces := []string{}
+	byNamespace := make(map[string][]string)
+	for _, key := range keys {
+		namespace := pods[key][0].namespace
+		if _, ok := byNamespace[namespace]; !ok {
+			namespaces = append(namespaces, namespace)
+		}
+		byNamespace[namespace] = append(byNamespace[namespace], key)
+	}
+	selected := []string{}
+	for i := 0; len(selected) < max && len(selected) < len(keys); i++ {
+		for _, namespace := range namespaces {
+			if i < len(byNamespace[namespace]) && len(selected) < max {
+				selected = append(selected, byNamespace[namespace][i])
 			}
-			pods, err := clientset.CoreV1().Pods(ns).List(context.TODO(), listOptions)
+		}
+	}
+	return selected
+}
+
+// eachPod calls fn for the pods matching --pods-labels and the selectors of policies,
+// listing pods a page at a time until every pod is listed or fn returns false.
+// A pod matched more than once is only passed to fn once.
+func eachPod(ctx context.Context, clientset kubernetes.Interface, namespaces []string, logger log.Logger, fn func(pod *v1.Pod) bool) error {
+	seen := make(map[string]bool)
+	done := false
+	listed := 0
+	var lastErr error
+	list := func(ns string, selector string) {
+		listOptions := metav1.ListOptions{
+			LabelSelector: selector,
+		}
+		err := listPages(ctx, logger, listOptions, func(options metav1.ListOptions) (string, bool, error) {
+			podList, err := clientset.CoreV1().Pods(ns).List(ctx, options)
 			if err != nil {
-				level.Error(logger).Log("msg", "Error getting pod list", "label", l, "namespace", ns, "err", err)
-				return nil, err
+				return "", false, err
 			}
-			for _, pod := range pods.Items {
-				if *reapMax != 0 && toReap >= *reapMax {
-					level.Info(logger).Log("msg", "Max reap reached, skipping rest", "max", *reapMax)
-					return jobs, nil
-				}
-				podLogger := log.With(logger, "pod", pod.Name, "namespace", pod.Namespace)
-				var lifetime time.Duration
-				if val, ok := pod.Annotations[lifetimeAnnotation]; !ok {
-					level.Debug(podLogger).Log("msg", "Pod lacks reaper annotation, skipping", "annotation", lifetimeAnnotation)
+			for i := range podList.Items {
+				pod := &podList.Items[i]
+				key := pod.Namespace + "/" + pod.Name
+				if seen[key] {
 					continue
-				} else {
-					level.Debug(podLogger).Log("msg", "Found pod with reaper annotation", "annotation", val)
-					lifetime, err = time.ParseDuration(val)
-					if err != nil {
-						level.Error(podLogger).Log("msg", "Error parsing annotation, SKIPPING", "annotation", val, "err", err)
-						continue
-					}
-				}
-				var jobID string
-				if val, ok := pod.Labels[*jobLabel]; ok {
-					level.Debug(podLogger).Log("msg", "Pod has job label", "job", val)
-					jobID = val
-				} else {
-					level.Debug(podLogger).Log("msg", "Pod does not have job label, skipping")
 				}
-				var currentLifetime time.Duration
-				if *reapTimestamp == "start" && pod.Status.StartTime != nil {
-					currentLifetime = timeNow().Sub(pod.Status.StartTime.Time)
-				} else if *reapTimestamp == "creation" {
-					currentLifetime = timeNow().Sub(pod.CreationTimestamp.Time)
-				}
-				level.Debug(podLogger).Log("msg", "Pod lifetime", "lifetime", currentLifetime.Seconds())
-				if currentLifetime > lifetime {
-					level.Debug(podLogger).Log("msg", "Pod is past its lifetime and will be killed.")
-					job := podJob{jobID: jobID, podName: pod.Name, namespace: pod.Namespace}
-					jobs = append(jobs, job)
-				} else if *reapEvictedPods && strings.Contains(pod.Status.Reason, "Evicted") {
-					level.Debug(podLogger).Log("msg", "Pod is evicted and needs to be deleted.")
-					job := podJob{jobID: jobID, podName: pod.Name, namespace: pod.Namespace}
-					jobs = append(jobs, job)
+				seen[key] = true
+				if !fn(pod) {
+					done = true
+					return "", false, nil
 				}
 			}
+			return podList.Continue, true, nil
+		})
+		if err != nil {
+			runFailures.record("list", err)
+			level.Error(logger).Log("msg", errorMessage("Error getting pod list, skipping", err), "label", selector, "namespace", ns, "err", err)
+			lastErr = err
+			return
 		}
+		listed++
 	}
-	return jobs, nil
+	labels := strings.Split(*podsLabels, ",")
+	for _, ns := range namespaces {
+		for _, l := range labels {
+			list(ns, l)
+			if done {
+				return nil
+			}
+		}
+	}
+	for _, policy := range reaperPolicies.list() {
+		for _, ns := range policyNamespaces(policy, namespaces) {
+			list(ns, policy.selector.String())
+			if done {
+				return nil
+			}
+		}
+	}
+	// Pod lists that fail are skipped unless no pods could be listed
+	if listed == 0 {
+		return lastErr
+	}
+	return nil
 }
 
-func getJobObjects(clientset kubernetes.Interface, jobs []podJob, logger log.Logger) ([]jobObject, error) {
-	jobObjects := []jobObject{}
-	for _, job := range jobs {
-		jobObjects = append(jobObjects, jobObject{objectType: "pod", name: job.podName, namespace: job.namespace})
-		jobLogger := log.With(logger, "job", job.jobID, "namespace", job.namespace)
-		listOptions := metav1.ListOptions{
-			LabelSelector: fmt.Sprintf("%s=%s", *jobLabel, job.jobID),
+// podTracked returns whether the pod has a lifetime from its annotations or namespace
+func podTracked(pod *v1.Pod) bool {
+	if _, ok := pod.Annotations[lifetimeAnnotation]; ok {
+		return true
+	}
+	if _, ok := pod.Annotations[expiresAtAnnotation]; ok {
+		return true
+	}
+	if policy := reaperPolicies.match(pod); policy != nil && policy.lifetime != 0 {
+		return true
+	}
+	return namespacePolicies.get(pod.Namespace).defaultLifetime != 0
+}
+
+func evaluatePod(ctx context.Context, clientset kubernetes.Interface, pod *v1.Pod, logger log.Logger) (podJob, bool) {
+	// Pods being gracefully terminated have already been deleted
+	if pod.DeletionTimestamp != nil {
+		level.Debug(logger).Log("msg", "Pod is terminating, skipping")
+		return podJob{}, false
+	}
+	expiry, rule, ok := podExpiry(pod, logger)
+	condition, stuck := podCondition(pod)
+	if !ok && !stuck {
+		return podJob{}, false
+	}
+	policy := reaperPolicies.match(pod)
+	if policy != nil {
+		level.Debug(logger).Log("msg", "Pod matches policy", "policy", policy.key())
+	}
+	var jobID string
+	if val, ok := pod.Labels[jobLabelFor(policy)]; ok {
+		level.Debug(logger).Log("msg", "Pod has job label", "job", val)
+		jobID = val
+	} else {
+		level.Debug(logger).Log("msg", "Pod does not have job label, skipping")
+	}
+	var currentLifetime, lifetime time.Duration
+	if timestamp, ok := podTimestamp(pod); ok {
+		currentLifetime = timeNow().Sub(timestamp)
+		if !expiry.IsZero() {
+			lifetime = expiry.Sub(timestamp)
 		}
-		services, err := clientset.CoreV1().Services(job.namespace).List(context.TODO(), listOptions)
-		if err != nil {
-			level.Error(jobLogger).Log("msg", "Error getting services", "err", err)
-			return nil, err
+	}
+	level.Debug(logger).Log("msg", "Pod lifetime", "lifetime", currentLifetime.Seconds())
+	job := podJob{jobID: jobID, podName: pod.Name, namespace: pod.Namespace, age: currentLifetime, lifetime: lifetime, policy: policy,
+		owners: pod.OwnerReferences}
+	if !expiry.IsZero() && timeNow().After(expiry) {
+		level.Debug(logger).Log("msg", "Pod is past its expiry and will be killed.", "rule", rule, "expiry", expiry)
+		job.reason = rule
+		job.overdue = timeNow().Sub(expiry)
+		return job, true
+	} else if reapEvictedForPolicy(policy, pod.Namespace) && strings.Contains(pod.Status.Reason, "Evicted") {
+		level.Debug(logger).Log("msg", "Pod is evicted and needs to be deleted.")
+		job.reason = reasonEvicted
+		job.overdue = currentLifetime
+		return job, true
+	} else if stuck {
+		level.Debug(logger).Log("msg", "Pod is stuck and needs to be deleted.", "rule", condition)
+		job.reason = condition
+		job.overdue = currentLifetime
+		return job, true
+	}
+	if *warningWindow > 0 && !expiry.IsZero() && expiry.Sub(timeNow()) <= *warningWindow {
+		warnPod(ctx, clientset, pod, expiry, logger)
+	}
+	return podJob{}, false
+}
+
+// warnPod stamps the reap-at annotation on a pod that is within the warning
+// window of its lifetime and records a warning Event against the pod
+func warnPod(ctx context.Context, clientset kubernetes.Interface, pod *v1.Pod, expiry time.Time, logger log.Logger) {
+	reapAt := expiry.UTC().Format(time.RFC3339)
+	if pod.Annotations[reapAtAnnotation] == reapAt {
+		level.Debug(logger).Log("msg", "Pod already warned of expiry", "reap_at", reapAt)
+		return
+	}
+	level.Info(logger).Log("msg", "Pod is expiring soon", "reap_at", reapAt, "dry_run", *dryRun)
+	if *dryRun == dryRunClient {
+		return
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]string{
+				reapAtAnnotation: reapAt,
+			},
+		},
+	})
+	if err != nil {
+		level.Error(logger).Log("msg", "Error generating reap-at patch", "err", err)
+		return
+	}
+	patchOptions := metav1.PatchOptions{}
+	if *dryRun == dryRunServer {
+		patchOptions.DryRun = []string{metav1.DryRunAll}
+	}
+	_, err = clientset.CoreV1().Pods(pod.Namespace).Patch(ctx, pod.Name, types.MergePatchType, patch, patchOptions)
+	if err != nil {
+		level.Error(logger).Log("msg", "Error annotating pod with reap-at", "err", err)
+		return
+	}
+	if *dryRun == dryRunNone && recorder != nil {
+		recorder.Eventf(pod, v1.EventTypeWarning, eventReasonExpiring, "Pod will be reaped at %s when it exceeds its lifetime", reapAt)
+	}
+}
+
+func podLifetime(pod *v1.Pod, logger log.Logger) (time.Duration, bool) {
+	var lifetime time.Duration
+	if val, ok := pod.Annotations[lifetimeAnnotation]; !ok {
+		if reaperPolicy := reaperPolicies.match(pod); reaperPolicy != nil && reaperPolicy.lifetime != 0 {
+			level.Debug(logger).Log("msg", "Pod lacks lifetime annotation, using policy lifetime", "policy", reaperPolicy.key(), "lifetime", reaperPolicy.lifetime)
+			lifetime = reaperPolicy.lifetime
+		} else if policy := namespacePolicies.get(pod.Namespace); policy.defaultLifetime != 0 {
+			level.Debug(logger).Log("msg", "Pod lacks lifetime annotation, using namespace default lifetime", "lifetime", policy.defaultLifetime)
+			lifetime = policy.defaultLifetime
+		} else {
+			level.Debug(logger).Log("msg", "Pod lacks lifetime annotation", "annotation", lifetimeAnnotation)
+			return 0, false
 		}
-		for _, service := range services.Items {
-			jobObject := jobObject{objectType: "service", name: service.Name, namespace: service.Namespace}
-			jobObjects = append(jobObjects, jobObject)
+	} else {
+		level.Debug(logger).Log("msg", "Found pod with reaper annotation", "annotation", val)
+		var err error
+		lifetime, err = parseLifetime(val)
+		if err != nil {
+			level.Error(logger).Log("msg", "Error parsing annotation, SKIPPING", "annotation", val, "err", err)
+			recordInvalidAnnotation(pod, lifetimeAnnotation, val, err)
+			return 0, false
 		}
-		configmaps, err := clientset.CoreV1().ConfigMaps(job.namespace).List(context.TODO(), listOptions)
+	}
+	maxLifetime := maxLifetimeFor(pod.Namespace)
+	if maxLifetime != 0 && lifetime > maxLifetime {
+		level.Info(logger).Log("msg", "Pod lifetime exceeds max lifetime, using max lifetime", "lifetime", lifetime, "max", maxLifetime)
+		lifetime = maxLifetime
+	}
+	if val, ok := pod.Annotations[lifetimeExtensionAnnotation]; ok {
+		lifetime = extendLifetime(pod, lifetime, maxLifetime, val, logger)
+	}
+	return lifetime, true
+}
+
+// extendLifetime adds the comma separated durations of the lifetime extension
+// annotation to the lifetime, ignoring extensions beyond maxLifetime or --max-extensions
+func extendLifetime(pod *v1.Pod, lifetime time.Duration, maxLifetime time.Duration, extensions string, logger log.Logger) time.Duration {
+	extended := 0
+	for _, e := range strings.Split(extensions, ",") {
+		extension, err := parseLifetime(e)
 		if err != nil {
-			level.Error(jobLogger).Log("msg", "Error getting config maps", "err", err)
-			return nil, err
+			level.Error(logger).Log("msg", "Error parsing lifetime extension, ignoring", "extension", e, "err", err)
+			recordInvalidAnnotation(pod, lifetimeExtensionAnnotation, extensions, err)
+			continue
 		}
-		for _, configmap := range configmaps.Items {
-			jobObject := jobObject{objectType: "configmap", name: configmap.Name, namespace: configmap.Namespace}
-			jobObjects = append(jobObjects, jobObject)
+		if *maxExtensions != 0 && extended >= *maxExtensions {
+			level.Warn(logger).Log("msg", "Max lifetime extensions reached, ignoring extension", "extension", extension, "max", *maxExtensions)
+			continue
 		}
-		secrets, err := clientset.CoreV1().Secrets(job.namespace).List(context.TODO(), listOptions)
-		if err != nil {
-			level.Error(jobLogger).Log("msg", "Error getting secrets", "err", err)
-			return nil, err
+		if maxLifetime != 0 && lifetime+extension > maxLifetime {
+			level.Warn(logger).Log("msg", "Lifetime extension exceeds max lifetime, ignoring extension",
+				"lifetime", lifetime, "extension", extension, "max", maxLifetime)
+			continue
+		}
+		level.Debug(logger).Log("msg", "Extending pod lifetime", "lifetime", lifetime, "extension", extension)
+		lifetime += extension
+		extended++
+	}
+	return lifetime
+}
+
+// podTimestamp returns the pod timestamp defined by the pod's policy or --reap-timestamp
+func podTimestamp(pod *v1.Pod) (time.Time, bool) {
+	basis := *reapTimestamp
+	if policy := reaperPolicies.match(pod); policy != nil && policy.timestamp != "" {
+		basis = policy.timestamp
+	}
+	if basis == "start" && pod.Status.StartTime != nil {
+		return pod.Status.StartTime.Time, true
+	} else if basis == "creation" {
+		return pod.CreationTimestamp.Time, true
+	}
+	return time.Time{}, false
+}
+
+// podExpiry returns the time the pod expires and the rule that determined it.
+// Whichever of the lifetime and expires-at annotations expires first is used.
+// The expiry is zero if the pod has a lifetime but its timestamp is not yet known.
+func podExpiry(pod *v1.Pod, logger log.Logger) (time.Time, string, bool) {
+	var expiry time.Time
+	var rule string
+	lifetime, hasLifetime := podLifetime(pod, logger)
+	if timestamp, ok := podTimestamp(pod); hasLifetime && ok {
+		expiry = timestamp.Add(lifetime)
+		rule = reasonLifetime
+	}
+	expiresAt, hasExpiresAt := podExpiresAt(pod, logger)
+	maxLifetime := maxLifetimeFor(pod.Namespace)
+	if timestamp, ok := podTimestamp(pod); hasExpiresAt && ok && maxLifetime != 0 && expiresAt.After(timestamp.Add(maxLifetime)) {
+		level.Info(logger).Log("msg", "Pod expires-at exceeds max lifetime, using max lifetime", "expires_at", expiresAt, "max", maxLifetime)
+		expiresAt = timestamp.Add(maxLifetime)
+	}
+	if hasExpiresAt && (expiry.IsZero() || expiresAt.Before(expiry)) {
+		expiry = expiresAt
+		rule = reasonExpiresAt
+	}
+	if !hasLifetime && !hasExpiresAt {
+		return time.Time{}, "", false
+	}
+	return expiry, rule, true
+}
+
+// recordInvalidAnnotation records a warning Event so the pod owner knows
+// the reaper annotation could not be parsed
+func recordInvalidAnnotation(pod *v1.Pod, annotation string, value string, err error) {
+	if recorder == nil || *dryRun != dryRunNone {
+		return
+	}
+	recorder.Eventf(pod, v1.EventTypeWarning, eventReasonInvalid, "Unable to parse annotation %s=%q, it is ignored and may prevent the pod from being reaped: %v", annotation, value, err)
+}
+
+func podExpiresAt(pod *v1.Pod, logger log.Logger) (time.Time, bool) {
+	val, ok := pod.Annotations[expiresAtAnnotation]
+	if !ok {
+		return time.Time{}, false
+	}
+	level.Debug(logger).Log("msg", "Found pod with expires-at annotation", "annotation", val)
+	expiresAt, err := time.Parse(time.RFC3339, val)
+	if err != nil {
+		level.Error(logger).Log("msg", "Error parsing expires-at annotation, SKIPPING", "annotation", val, "err", err)
+		recordInvalidAnnotation(pod, expiresAtAnnotation, val, err)
+		return time.Time{}, false
+	}
+	return expiresAt, true
+}
+
+// getJobObjects returns the objects of each job starting with its pods, jobs whose objects
+// can not be listed are skipped and the last error is returned with the remaining objects
+func getJobObjects(ctx context.Context, clientset kubernetes.Interface, jobs []podJob, logger log.Logger) ([]jobObject, error) {
+	// Pods of the same job are not always listed together so they are gathered by job first
+	keys := []string{}
+	pods := make(map[string][]podJob)
+	for _, job := range jobs {
+		key := job.key()
+		if _, ok := pods[key]; !ok {
+			keys = append(keys, key)
 		}
-		for _, secret := range secrets.Items {
-			jobObject := jobObject{objectType: "secret", name: secret.Name, namespace: secret.Namespace}
-			jobObjects = append(jobObjects, jobObject)
+		pods[key] = append(pods[key], job)
+	}
+	jobObjects := []jobObject{}
+	var lastErr error
+	for _, key := range keys {
+		objects, err := listJobObjects(ctx, clientset, pods[key], logger)
+		if err != nil {
+			runFailures.record("list", err)
+			lastErr = err
+			continue
 		}
+		jobObjects = append(jobObjects, objects...)
 	}
-	return jobObjects, nil
+	return jobObjects, lastErr
 }
 
-func reap(clientset kubernetes.Interface, jobObjects []jobObject, logger log.Logger) error {
-	deletedPods := 0
-	deletedServices := 0
-	deletedConfigMaps := 0
-	deletedSecrets := 0
-	for _, job := range jobObjects {
-		reapLogger := log.With(logger, "job", job.jobID, "name", job.name, "namespace", job.namespace)
-		if job.objectType == "pod" {
-			err := clientset.CoreV1().Pods(job.namespace).Delete(context.TODO(), job.name, metav1.DeleteOptions{})
+// listJobObjects returns the pods of a job followed by the job's other objects, which are listed once for the job
+func listJobObjects(ctx context.Context, clientset kubernetes.Interface, pods []podJob, logger log.Logger) ([]jobObject, error) {
+	job := pods[0]
+	jobObjects := []jobObject{}
+	jobLogger := log.With(logger, "job", job.jobID, "namespace", job.namespace)
+	owners := make(map[string]bool)
+	for _, pod := range pods {
+		podObject := jobObject{objectType: "pod", jobID: pod.jobID, name: pod.podName, namespace: pod.namespace,
+			reason: pod.reason, age: pod.age, lifetime: pod.lifetime, policy: pod.policy}
+		// Evicted pods have already been replaced by their controller
+		if *reapOwners && pod.reason != reasonEvicted {
+			owner, err := topController(ctx, clientset, pod, jobLogger)
 			if err != nil {
-				level.Error(reapLogger).Log("msg", "Error deleting pod", "err", err)
-				continue
+				return nil, err
 			}
-			level.Info(reapLogger).Log("msg", "Pod deleted")
-			deletedPods++
-		}
-		if job.objectType == "service" {
-			err := clientset.CoreV1().Services(job.namespace).Delete(context.TODO(), job.name, metav1.DeleteOptions{})
-			if err != nil {
-				level.Error(reapLogger).Log("msg", "Error deleting service", "err", err)
-				continue
+			if owner != nil {
+				// Pods sharing an owner are deleted with it
+				ownerKey := owner.objectType + "/" + owner.name
+				if owners[ownerKey] {
+					continue
+				}
+				owners[ownerKey] = true
 			}
-			level.Info(reapLogger).Log("msg", "Service deleted")
-			deletedServices++
+			podObject.owner = owner
 		}
-		if job.objectType == "configmap" {
-			err := clientset.CoreV1().ConfigMaps(job.namespace).Delete(context.TODO(), job.name, metav1.DeleteOptions{})
+		jobObjects = append(jobObjects, podObject)
+	}
+	listOptions := metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("%s=%s", jobLabelFor(job.policy), job.jobID),
+	}
+	relatedKinds := relatedKindsFor(job.policy)
+	for _, objectType := range relatedObjectTypes {
+		if !sliceContains(relatedKinds, objectType) {
+			continue
+		}
+		err := listPages(ctx, logger, listOptions, func(options metav1.ListOptions) (string, bool, error) {
+			objects, next, err := listObjects(ctx, clientset, objectType, job.namespace, options)
 			if err != nil {
-				level.Error(reapLogger).Log("msg", "Error deleting config map", "err", err)
-				continue
+				return "", false, err
+			}
+			for _, object := range objects {
+				jobObject := jobObject{objectType: objectType, jobID: job.jobID, name: object.GetName(), namespace: job.namespace,
+					reason: job.reason, age: job.age, lifetime: job.lifetime, policy: job.policy}
+				jobObjects = append(jobObjects, jobObject)
 			}
-			level.Info(reapLogger).Log("msg", "ConfigMap deleted")
-			deletedConfigMaps++
+			return next, true, nil
+		})
+		if err != nil {
+			level.Error(jobLogger).Log("msg", errorMessage("Error getting "+objectKinds[objectType].resource, err), "err", err)
+			return nil, err
 		}
-		if job.objectType == "secret" {
-			err := clientset.CoreV1().Secrets(job.namespace).Delete(context.TODO(), job.name, metav1.DeleteOptions{})
+	}
+	for _, resource := range reapResourcesFor(job.policy) {
+		err := listPages(ctx, logger, listOptions, func(options metav1.ListOptions) (string, bool, error) {
+			objects, next, err := listResourceObjects(ctx, resource, job.namespace, options)
 			if err != nil {
-				level.Error(reapLogger).Log("msg", "Error deleting secret", "err", err)
-				continue
+				return "", false, err
+			}
+			for _, object := range objects {
+				jobObject := jobObject{objectType: resource.name, jobID: job.jobID, name: object.GetName(), namespace: job.namespace,
+					reason: job.reason, age: job.age, lifetime: job.lifetime, policy: job.policy, resource: resource}
+				jobObjects = append(jobObjects, jobObject)
 			}
-			level.Info(reapLogger).Log("msg", "Secret deleted")
-			deletedSecrets++
+			return next, true, nil
+		})
+		if err != nil {
+			level.Error(jobLogger).Log("msg", errorMessage("Error getting "+resource.name, err), "err", err)
+			return nil, err
 		}
 	}
-	level.Info(logger).Log("msg", "Reap summary",
-		"pods", deletedPods, "services", deletedServices, "configmaps", deletedConfigMaps, "secrets", deletedSecrets)
+	return jobObjects, nil
+}
+
+// listObjects lists a page of objects of the object type and returns the objects and the continue token
+func listObjects(ctx context.Context, clientset kubernetes.Interface, objectType string, namespace string, options metav1.ListOptions) ([]metav1.Object, string, error) {
+	var list runtime.Object
+	var err error
+	switch objectType {
+	case "service":
+		list, err = clientset.CoreV1().Services(namespace).List(ctx, options)
+	case "configmap":
+		list, err = clientset.CoreV1().ConfigMaps(namespace).List(ctx, options)
+	case "secret":
+		list, err = clientset.CoreV1().Secrets(namespace).List(ctx, options)
+	case "persistentvolumeclaim":
+		list, err = clientset.CoreV1().PersistentVolumeClaims(namespace).List(ctx, options)
+	case "ingress":
+		list, err = clientset.NetworkingV1().Ingresses(namespace).List(ctx, options)
+	case "networkpolicy":
+		list, err = clientset.NetworkingV1().NetworkPolicies(namespace).List(ctx, options)
+	case "serviceaccount":
+		list, err = clientset.CoreV1().ServiceAccounts(namespace).List(ctx, options)
+	case "rolebinding":
+		list, err = clientset.RbacV1().RoleBindings(namespace).List(ctx, options)
+	case "endpoints":
+		list, err = clientset.CoreV1().Endpoints(namespace).List(ctx, options)
+	default:
+		return nil, "", fmt.Errorf("unknown object type %s", objectType)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	objects := []metav1.Object{}
+	err = apimeta.EachListItem(list, func(obj runtime.Object) error {
+		accessor, err := apimeta.Accessor(obj)
+		if err != nil {
+			return err
+		}
+		objects = append(objects, accessor)
+		return nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	listAccessor, err := apimeta.ListAccessor(list)
+	if err != nil {
+		return nil, "", err
+	}
+	return objects, listAccessor.GetContinue(), nil
+}
+
+func reap(ctx context.Context, clientset kubernetes.Interface, jobObjects []jobObject, logger log.Logger) error {
+	deleted := reapObjects(ctx, clientset, jobObjects, logger)
+	summary := []interface{}{"msg", "Reap summary", "dry_run", *dryRun, "pods", deleted["pod"]}
+	for _, objectType := range relatedObjectTypes {
+		summary = append(summary, objectKinds[objectType].resource, deleted[objectType])
+	}
+	for _, resource := range reapResourcesStore.list() {
+		summary = append(summary, resource.name, deleted[resource.name])
+	}
+	if *reapOwners {
+		for _, objectType := range ownerObjectTypes {
+			summary = append(summary, objectKinds[objectType].resource, deleted[objectType])
+		}
+	}
+	summary = append(summary, "backlog", backlogSummary(),
+		"list_failures", runFailures.get("list"), "delete_failures", runFailures.get("delete"), "forbidden", runFailures.get("forbidden"))
+	level.Info(logger).Log(summary...)
 	return nil
 }
 
+// reapObjects deletes the objects of each job using --reap-workers and returns the number
+// of objects deleted of each type, jobs not started when ctx is done are skipped
+func reapObjects(ctx context.Context, clientset kubernetes.Interface, jobObjects []jobObject, logger log.Logger) map[string]int {
+	deleted := make(map[string]int)
+	var deletedLock sync.Mutex
+	deleteOptions := metav1.DeleteOptions{}
+	if *dryRun == dryRunServer {
+		deleteOptions.DryRun = []string{metav1.DryRunAll}
+	}
+	propagation := metav1.DeletionPropagation(*propagationPolicy)
+	ownerDeleteOptions := deleteOptions
+	ownerDeleteOptions.PropagationPolicy = &propagation
+	// Deletions are not cancelled by ctx so that once a job's pod is deleted
+	// the rest of the job's objects are deleted before shutting down
+	deleteCtx := context.Background()
+	var skipped int64
+	jobs := make(chan []jobObject)
+	var wg sync.WaitGroup
+	for i := 0; i < *reapWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for objects := range jobs {
+				if ctx.Err() != nil {
+					atomic.AddInt64(&skipped, 1)
+					continue
+				}
+				for _, job := range objects {
+					options := deleteOptions
+					if job.owner != nil {
+						job = *job.owner
+						options = ownerDeleteOptions
+					}
+					if reapObject(deleteCtx, clientset, job, options, logger) {
+						deletedLock.Lock()
+						deleted[job.objectType]++
+						deletedLock.Unlock()
+					}
+				}
+			}
+		}()
+	}
+	groups := groupJobObjects(jobObjects)
+	for i, objects := range groups {
+		if ctx.Err() != nil {
+			atomic.AddInt64(&skipped, int64(len(groups)-i))
+			break
+		}
+		jobs <- objects
+	}
+	close(jobs)
+	wg.Wait()
+	if skipped > 0 {
+		level.Info(logger).Log("msg", "Shutting down, skipped remaining jobs", "jobs", skipped)
+	}
+	return deleted
+}
+
+// groupJobObjects splits objects into jobs, each job's objects start with its pods
+// or, for objects without a pod, with the first object of the job. The objects of a job
+// are expected together and a pod without a job label starts a job of its own
+func groupJobObjects(jobObjects []jobObject) [][]jobObject {
+	groups := [][]jobObject{}
+	for i, job := range jobObjects {
+		if i == 0 || job.jobID != jobObjects[i-1].jobID || job.namespace != jobObjects[i-1].namespace ||
+			(job.objectType == "pod" && job.jobID == "") {
+			groups = append(groups, []jobObject{})
+		}
+		groups[len(groups)-1] = append(groups[len(groups)-1], job)
+	}
+	return groups
+}
+
+// limitJobObjects returns the objects of whole jobs until max objects are reached,
+// the first job is always returned so a job with more than max objects is still reaped
+func limitJobObjects(jobObjects []jobObject, max int, logger log.Logger) []jobObject {
+	if max == 0 || len(jobObjects) <= max {
+		return jobObjects
+	}
+	groups := groupJobObjects(jobObjects)
+	limited := []jobObject{}
+	for i, objects := range groups {
+		if len(limited) > 0 && len(limited)+len(objects) > max {
+			backlog := len(groups) - i
+			level.Info(logger).Log("msg", "Max reap objects reached, skipping rest", "max", max, "skipped", backlog)
+			atomic.AddInt64(&runBacklog, int64(backlog))
+			break
+		}
+		limited = append(limited, objects...)
+	}
+	return limited
+}
+
+// reapObject deletes a job object and returns whether it was, or would be, deleted
+func reapObject(ctx context.Context, clientset kubernetes.Interface, job jobObject, deleteOptions metav1.DeleteOptions, logger log.Logger) bool {
+	reapLogger := log.With(logger, "job", job.jobID, "type", job.objectType, "name", job.name, "namespace", job.namespace, "reason", job.reason)
+	if *dryRun == dryRunClient {
+		level.Info(reapLogger).Log("msg", "Would delete", "age", job.age, "lifetime", job.lifetime)
+		return true
+	}
+	err := withRetry(ctx, reapLogger, func() error {
+		return deleteObject(ctx, clientset, job, deleteOptions)
+	})
+	if apierrors.IsNotFound(err) {
+		level.Debug(reapLogger).Log("msg", "Object already deleted")
+		return false
+	}
+	if err != nil {
+		runFailures.record("delete", err)
+		level.Error(reapLogger).Log("msg", errorMessage("Error deleting object", err), "err", err)
+		metricErrors.WithLabelValues(job.objectType, job.namespace).Inc()
+		return false
+	}
+	if *dryRun == dryRunServer {
+		level.Info(reapLogger).Log("msg", "Would delete, server dry run succeeded", "age", job.age, "lifetime", job.lifetime)
+		return true
+	}
+	level.Info(reapLogger).Log("msg", "Object deleted")
+	recordReapEvent(job)
+	if job.policy != nil {
+		job.policy.recordReaped(job.objectType)
+	}
+	metricDeleted.WithLabelValues(job.objectType, job.namespace, job.reason).Inc()
+	return true
+}
+
+// recordReapEvent records an Event against the object that was reaped and,
+// for pods, against the namespace so users can see why their job is gone
+func recordReapEvent(job jobObject) {
+	if recorder == nil {
+		return
+	}
+	reason := eventReasonReaped
+	message := fmt.Sprintf("Reaped %s %s of job %s, age %s exceeded lifetime %s", job.objectType, job.name, job.jobID, job.age, job.lifetime)
+	if job.reason == reasonEvicted {
+		reason = eventReasonEvicted
+		message = fmt.Sprintf("Reaped %s %s of evicted job %s, age %s lifetime %s", job.objectType, job.name, job.jobID, job.age, job.lifetime)
+	} else if sliceContains(conditionReasons, job.reason) {
+		reason = eventReasonStuck
+		message = fmt.Sprintf("Reaped %s %s of job %s stuck in %s, age %s lifetime %s", job.objectType, job.name, job.jobID, job.reason, job.age, job.lifetime)
+	} else if job.reason == reasonOrphaned {
+		message = fmt.Sprintf("Reaped %s %s of job %s, job has had no pods for %s", job.objectType, job.name, job.jobID, job.age)
+	}
+	ref := &v1.ObjectReference{
+		APIVersion: objectKinds[job.objectType].apiVersion,
+		Kind:       objectKinds[job.objectType].kind,
+		Namespace:  job.namespace,
+		Name:       job.name,
+	}
+	if job.resource != nil {
+		ref.APIVersion = job.resource.gvr.GroupVersion().String()
+		ref.Kind = job.resource.kind
+	}
+	recorder.Event(ref, v1.EventTypeNormal, reason, message)
+	if job.objectType == "pod" || sliceContains(ownerObjectTypes, job.objectType) {
+		nsRef := &v1.ObjectReference{
+			APIVersion: "v1",
+			Kind:       "Namespace",
+			Namespace:  job.namespace,
+			Name:       job.namespace,
+		}
+		recorder.Event(nsRef, v1.EventTypeNormal, reason, message)
+	}
+}
+
+func deleteObject(ctx context.Context, clientset kubernetes.Interface, job jobObject, deleteOptions metav1.DeleteOptions) error {
+	if job.resource != nil {
+		return dynamicClient.Resource(job.resource.gvr).Namespace(job.namespace).Delete(ctx, job.name, deleteOptions)
+	}
+	switch job.objectType {
+	case "pod":
+		return clientset.CoreV1().Pods(job.namespace).Delete(ctx, job.name, deleteOptions)
+	case "service":
+		return clientset.CoreV1().Services(job.namespace).Delete(ctx, job.name, deleteOptions)
+	case "configmap":
+		return clientset.CoreV1().ConfigMaps(job.namespace).Delete(ctx, job.name, deleteOptions)
+	case "secret":
+		return clientset.CoreV1().Secrets(job.namespace).Delete(ctx, job.name, deleteOptions)
+	case "persistentvolumeclaim":
+		return clientset.CoreV1().PersistentVolumeClaims(job.namespace).Delete(ctx, job.name, deleteOptions)
+	case "ingress":
+		return clientset.NetworkingV1().Ingresses(job.namespace).Delete(ctx, job.name, deleteOptions)
+	case "networkpolicy":
+		return clientset.NetworkingV1().NetworkPolicies(job.namespace).Delete(ctx, job.name, deleteOptions)
+	case "serviceaccount":
+		return clientset.CoreV1().ServiceAccounts(job.namespace).Delete(ctx, job.name, deleteOptions)
+	case "rolebinding":
+		return clientset.RbacV1().RoleBindings(job.namespace).Delete(ctx, job.name, deleteOptions)
+	case "endpoints":
+		return clientset.CoreV1().Endpoints(job.namespace).Delete(ctx, job.name, deleteOptions)
+	case "job":
+		return clientset.BatchV1().Jobs(job.namespace).Delete(ctx, job.name, deleteOptions)
+	case "deployment":
+		return clientset.AppsV1().Deployments(job.namespace).Delete(ctx, job.name, deleteOptions)
+	case "statefulset":
+		return clientset.AppsV1().StatefulSets(job.namespace).Delete(ctx, job.name, deleteOptions)
+	case "replicaset":
+		return clientset.AppsV1().ReplicaSets(job.namespace).Delete(ctx, job.name, deleteOptions)
+	case "workflow":
+		return dynamicClient.Resource(workflowResource).Namespace(job.namespace).Delete(ctx, job.name, deleteOptions)
+	}
+	return fmt.Errorf("unknown object type %s", job.objectType)
+}
+
+// listPages calls list with options limited to --list-page-size until list
+// returns an empty continue token or false, each page is retried on transient errors
+func listPages(ctx context.Context, logger log.Logger, options metav1.ListOptions, list func(options metav1.ListOptions) (string, bool, error)) error {
+	options.Limit = int64(*listPageSize)
+	for {
+		var next string
+		var more bool
+		err := withRetry(ctx, logger, func() error {
+			var err error
+			next, more, err = list(options)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		if !more || next == "" {
+			return nil
+		}
+		options.Continue = next
+	}
+}
+
 func sliceContains(slice []string, str string) bool {
 	for _, s := range slice {
 		if str == s {
@@ -15,15 +15,28 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/go-kit/kit/log"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	kingpin "gopkg.in/alecthomas/kingpin.v2"
 	v1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/kubernetes/fake"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	clienttesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/record"
 )
 
 var (
@@ -164,7 +177,7 @@ func TestGetNamespaces(t *testing.T) {
 	}
 	w := log.NewSyncWriter(os.Stderr)
 	logger := log.NewLogfmtLogger(w)
-	namespaces, err := getNamespaces(clientset, logger)
+	namespaces, err := getNamespaces(context.TODO(), clientset, logger)
 	if err != nil {
 		t.Errorf("Unexpected error: %v", err)
 	}
@@ -184,7 +197,7 @@ func TestGetNamespacesByLabel(t *testing.T) {
 	namespaceLabels = &labels
 	w := log.NewSyncWriter(os.Stderr)
 	logger := log.NewLogfmtLogger(w)
-	namespaces, err := getNamespaces(clientset, logger)
+	namespaces, err := getNamespaces(context.TODO(), clientset, logger)
 	namespaceLabels = &noString
 	if err != nil {
 		t.Errorf("Unexpected error: %v", err)
@@ -212,11 +225,11 @@ func TestGetJobs(t *testing.T) {
 		return t
 	}
 
-	namespaces, err := getNamespaces(clientset, logger)
+	namespaces, err := getNamespaces(context.TODO(), clientset, logger)
 	if err != nil {
 		t.Errorf("Unexpected error: %v", err)
 	}
-	jobs, err := getJobs(clientset, namespaces, logger)
+	jobs, err := getJobs(context.TODO(), clientset, namespaces, logger)
 	if err != nil {
 		t.Errorf("Unexpected error: %v", err)
 	}
@@ -247,11 +260,11 @@ func TestGetJobsCase1(t *testing.T) {
 		return t
 	}
 
-	namespaces, err := getNamespaces(clientset, logger)
+	namespaces, err := getNamespaces(context.TODO(), clientset, logger)
 	if err != nil {
 		t.Errorf("Unexpected error: %v", err)
 	}
-	jobs, err := getJobs(clientset, namespaces, logger)
+	jobs, err := getJobs(context.TODO(), clientset, namespaces, logger)
 	if err != nil {
 		t.Errorf("Unexpected error: %v", err)
 	}
@@ -279,12 +292,12 @@ func TestGetJobsNamespaceLabels(t *testing.T) {
 		return t
 	}
 
-	namespaces, err := getNamespaces(clientset, logger)
+	namespaces, err := getNamespaces(context.TODO(), clientset, logger)
 	namespaceLabels = &noString
 	if err != nil {
 		t.Errorf("Unexpected error: %v", err)
 	}
-	jobs, err := getJobs(clientset, namespaces, logger)
+	jobs, err := getJobs(context.TODO(), clientset, namespaces, logger)
 	if err != nil {
 		t.Errorf("Unexpected error: %v", err)
 	}
@@ -312,7 +325,7 @@ func TestRun(t *testing.T) {
 		return t
 	}
 
-	run(clientset, logger)
+	run(context.TODO(), clientset, logger)
 
 	pods, err := clientset.CoreV1().Pods(metav1.NamespaceAll).List(context.TODO(), metav1.ListOptions{})
 	if err != nil {
@@ -342,4 +355,1014 @@ func TestRun(t *testing.T) {
 	if len(secrets.Items) != 0 {
 		t.Errorf("Unexpected number of services, got: %d", len(secrets.Items))
 	}
+	if val := testutil.ToFloat64(metricDeleted.WithLabelValues("pod", "user-user1", reasonLifetime)); val != 1 {
+		t.Errorf("Unexpected deleted pods metric, got: %v", val)
+	}
+	if val := testutil.ToFloat64(metricDeleted.WithLabelValues("secret", "user-user2", reasonLifetime)); val != 1 {
+		t.Errorf("Unexpected deleted secrets metric, got: %v", val)
+	}
+	if val := testutil.ToFloat64(metricTrackedPods); val != 3 {
+		t.Errorf("Unexpected tracked pods metric, got: %v", val)
+	}
+	if val := testutil.ToFloat64(metricLastSuccess); val == 0 {
+		t.Errorf("Last success metric not set")
+	}
+}
+
+func TestRunDryRun(t *testing.T) {
+	if _, err := kingpin.CommandLine.Parse([]string{"--dry-run=client"}); err != nil {
+		t.Fatal(err)
+	}
+	w := log.NewSyncWriter(os.Stderr)
+	logger := log.NewLogfmtLogger(w)
+
+	labels := "app.kubernetes.io/managed-by=open-ondemand"
+	podsLabels = &labels
+
+	timeNow = func() time.Time {
+		t, _ := time.Parse("01/02/2006 15:04:05", "01/01/2020 15:00:00")
+		return t
+	}
+
+	clientset := fake.NewSimpleClientset(&v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "ondemand-job1",
+			Namespace: "user-user1",
+			Annotations: map[string]string{
+				"pod.kubernetes.io/lifetime": "1h",
+			},
+			Labels: map[string]string{
+				"job":                          "1",
+				"app.kubernetes.io/managed-by": "open-ondemand",
+			},
+		},
+		Status: v1.PodStatus{
+			StartTime: &podStartTime,
+		},
+	}, &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "service-job1",
+			Namespace: "user-user1",
+			Labels: map[string]string{
+				"job": "1",
+			},
+		},
+	})
+
+	run(context.TODO(), clientset, logger)
+
+	pods, err := clientset.CoreV1().Pods(metav1.NamespaceAll).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		t.Errorf("Unexpected error getting pods: %v", err)
+	}
+	if len(pods.Items) != 1 {
+		t.Errorf("Unexpected number of pods, got: %d", len(pods.Items))
+	}
+	services, err := clientset.CoreV1().Services(metav1.NamespaceAll).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		t.Errorf("Unexpected error getting services: %v", err)
+	}
+	if len(services.Items) != 1 {
+		t.Errorf("Unexpected number of services, got: %d", len(services.Items))
+	}
+}
+
+func TestLeaderElection(t *testing.T) {
+	if _, err := kingpin.CommandLine.Parse([]string{"--leader-elect"}); err != nil {
+		t.Fatal(err)
+	}
+	w := log.NewSyncWriter(os.Stderr)
+	logger := log.NewLogfmtLogger(w)
+
+	clientset := fake.NewSimpleClientset()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ran := false
+	leaderElection(ctx, clientset, logger, func(ctx context.Context) {
+		ran = true
+		cancel()
+	})
+	if !ran {
+		t.Errorf("Leader function did not run")
+	}
+	if _, err := clientset.CoordinationV1().Leases("job-pod-reaper").Get(context.TODO(), "job-pod-reaper", metav1.GetOptions{}); err != nil {
+		t.Errorf("Unexpected error getting lease: %v", err)
+	}
+}
+
+func TestReapEvents(t *testing.T) {
+	if _, err := kingpin.CommandLine.Parse([]string{}); err != nil {
+		t.Fatal(err)
+	}
+	w := log.NewSyncWriter(os.Stderr)
+	logger := log.NewLogfmtLogger(w)
+
+	fakeRecorder := record.NewFakeRecorder(10)
+	recorder = fakeRecorder
+	defer func() { recorder = nil }()
+
+	clientset := fake.NewSimpleClientset(&v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "ondemand-job1",
+			Namespace: "user-user1",
+		},
+	}, &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "secret-job1",
+			Namespace: "user-user1",
+		},
+	})
+	jobObjects := []jobObject{
+		{objectType: "pod", jobID: "1", name: "ondemand-job1", namespace: "user-user1", reason: reasonLifetime,
+			age: 2 * time.Hour, lifetime: time.Hour},
+		{objectType: "secret", jobID: "1", name: "secret-job1", namespace: "user-user1", reason: reasonEvicted},
+		{objectType: "service", jobID: "1", name: "service-job1", namespace: "user-user1", reason: reasonLifetime},
+		{objectType: "configmap", jobID: "1", name: "configmap-job1", namespace: "user-user1", reason: reasonLifetime},
+	}
+	// Objects that fail to be deleted have no event
+	clientset.PrependReactor("delete", "services", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		return true, nil, fmt.Errorf("error deleting service")
+	})
+	if err := reap(context.TODO(), clientset, jobObjects, logger); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	expected := []string{
+		"Normal Reaped Reaped pod ondemand-job1 of job 1, age 2h0m0s exceeded lifetime 1h0m0s",
+		"Normal Reaped Reaped pod ondemand-job1 of job 1, age 2h0m0s exceeded lifetime 1h0m0s",
+		"Normal EvictedCleanup Reaped secret secret-job1 of evicted job 1, age 0s lifetime 0s",
+	}
+	if len(fakeRecorder.Events) != len(expected) {
+		t.Fatalf("Unexpected number of events, got: %d", len(fakeRecorder.Events))
+	}
+	for _, e := range expected {
+		if event := <-fakeRecorder.Events; event != e {
+			t.Errorf("Unexpected event, got: %s", event)
+		}
+	}
+}
+
+func TestReapShutdown(t *testing.T) {
+	if _, err := kingpin.CommandLine.Parse([]string{}); err != nil {
+		t.Fatal(err)
+	}
+	w := log.NewSyncWriter(os.Stderr)
+	logger := log.NewLogfmtLogger(w)
+
+	clientset := fake.NewSimpleClientset(&v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "ondemand-job1",
+			Namespace: "user-user1",
+		},
+	}, &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "service-job1",
+			Namespace: "user-user1",
+		},
+	}, &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "ondemand-job2",
+			Namespace: "user-user2",
+		},
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	clientset.PrependReactor("delete", "pods", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		cancel()
+		return false, nil, nil
+	})
+	jobObjects := []jobObject{
+		{objectType: "pod", jobID: "1", name: "ondemand-job1", namespace: "user-user1", reason: reasonLifetime},
+		{objectType: "service", jobID: "1", name: "service-job1", namespace: "user-user1", reason: reasonLifetime},
+		{objectType: "pod", jobID: "2", name: "ondemand-job2", namespace: "user-user2", reason: reasonLifetime},
+	}
+	if err := reap(ctx, clientset, jobObjects, logger); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	services, err := clientset.CoreV1().Services("user-user1").List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(services.Items) != 0 {
+		t.Errorf("Expected service of job being reaped to be deleted, got: %d", len(services.Items))
+	}
+	pods, err := clientset.CoreV1().Pods(metav1.NamespaceAll).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pods.Items) != 1 || pods.Items[0].Name != "ondemand-job2" {
+		t.Errorf("Expected remaining jobs to be skipped, got: %v", pods.Items)
+	}
+}
+
+func TestReapWorkers(t *testing.T) {
+	if _, err := kingpin.CommandLine.Parse([]string{"--reap-workers=4"}); err != nil {
+		t.Fatal(err)
+	}
+	w := log.NewSyncWriter(os.Stderr)
+	logger := log.NewLogfmtLogger(w)
+
+	clientset := fake.NewSimpleClientset()
+	jobObjects := []jobObject{}
+	for i := 1; i <= 10; i++ {
+		name := fmt.Sprintf("ondemand-job%d", i)
+		jobID := strconv.Itoa(i)
+		if _, err := clientset.CoreV1().Pods("user-user1").Create(context.TODO(), &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: name}}, metav1.CreateOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := clientset.CoreV1().Services("user-user1").Create(context.TODO(), &v1.Service{ObjectMeta: metav1.ObjectMeta{Name: name}}, metav1.CreateOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		jobObjects = append(jobObjects,
+			jobObject{objectType: "pod", jobID: jobID, name: name, namespace: "user-user1", reason: reasonLifetime},
+			jobObject{objectType: "service", jobID: jobID, name: name, namespace: "user-user1", reason: reasonLifetime},
+		)
+	}
+	var lock sync.Mutex
+	order := make(map[string][]string)
+	clientset.PrependReactor("delete", "*", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		deleteAction := action.(clienttesting.DeleteAction)
+		lock.Lock()
+		defer lock.Unlock()
+		order[deleteAction.GetName()] = append(order[deleteAction.GetName()], action.GetResource().Resource)
+		return false, nil, nil
+	})
+	if err := reap(context.TODO(), clientset, jobObjects, logger); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	pods, _ := clientset.CoreV1().Pods(metav1.NamespaceAll).List(context.TODO(), metav1.ListOptions{})
+	if len(pods.Items) != 0 {
+		t.Errorf("Unexpected number of pods, got: %d", len(pods.Items))
+	}
+	services, _ := clientset.CoreV1().Services(metav1.NamespaceAll).List(context.TODO(), metav1.ListOptions{})
+	if len(services.Items) != 0 {
+		t.Errorf("Unexpected number of services, got: %d", len(services.Items))
+	}
+	for name, resources := range order {
+		if strings.Join(resources, ",") != "pods,services" {
+			t.Errorf("Unexpected deletion order for job %s, got: %v", name, resources)
+		}
+	}
+}
+
+func TestSelectJobs(t *testing.T) {
+	jobs := []podJob{
+		{jobID: "1", namespace: "user-user1", overdue: 1 * time.Hour},
+		{jobID: "2", namespace: "user-user1", overdue: 5 * time.Hour},
+		{jobID: "3", namespace: "user-user1", overdue: 4 * time.Hour},
+		{jobID: "4", namespace: "user-user2", overdue: 2 * time.Hour},
+		{jobID: "5", namespace: "user-user3", overdue: 3 * time.Hour},
+		{jobID: "6", namespace: "user-user2", overdue: 30 * time.Minute},
+	}
+	tests := map[string]string{
+		reapStrategyOldestOverdue: "2,3,5,4",
+		reapStrategyRoundRobin:    "2,5,4,3",
+	}
+	for strategy, expected := range tests {
+		if _, err := kingpin.CommandLine.Parse([]string{"--reap-strategy=" + strategy}); err != nil {
+			t.Fatal(err)
+		}
+		ids := []string{}
+		for _, job := range selectJobs(jobs, 4) {
+			ids = append(ids, job.jobID)
+		}
+		if strings.Join(ids, ",") != expected {
+			t.Errorf("Unexpected jobs selected by %s, got: %v expected: %s", strategy, ids, expected)
+		}
+	}
+}
+
+func TestGetJobsRoundRobin(t *testing.T) {
+	if _, err := kingpin.CommandLine.Parse([]string{"--reap-max=2", "--reap-strategy=round-robin"}); err != nil {
+		t.Fatal(err)
+	}
+	w := log.NewSyncWriter(os.Stderr)
+	logger := log.NewLogfmtLogger(w)
+
+	labels := ""
+	podsLabels = &labels
+	timeNow = func() time.Time {
+		t, _ := time.Parse("01/02/2006 15:04:05", "01/01/2020 15:00:00")
+		return t
+	}
+
+	clientset := fake.NewSimpleClientset()
+	for i, namespace := range []string{"user-user1", "user-user1", "user-user1", "user-user2"} {
+		pod := &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        fmt.Sprintf("ondemand-job%d", i+1),
+				Namespace:   namespace,
+				Labels:      map[string]string{"job": strconv.Itoa(i + 1)},
+				Annotations: map[string]string{"pod.kubernetes.io/lifetime": "1h"},
+			},
+			Status: v1.PodStatus{StartTime: &podStartTime},
+		}
+		if _, err := clientset.CoreV1().Pods(namespace).Create(context.TODO(), pod, metav1.CreateOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	jobs, err := getJobs(context.TODO(), clientset, []string{metav1.NamespaceAll}, logger)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(jobs) != 2 || jobs[0].namespace == jobs[1].namespace {
+		t.Errorf("Expected one job from each namespace, got: %v", jobs)
+	}
+}
+
+func TestGetJobsReapMaxCountsJobs(t *testing.T) {
+	if _, err := kingpin.CommandLine.Parse([]string{"--reap-max=2", "--reap-strategy=oldest-overdue"}); err != nil {
+		t.Fatal(err)
+	}
+	w := log.NewSyncWriter(os.Stderr)
+	logger := log.NewLogfmtLogger(w)
+
+	labels := ""
+	podsLabels = &labels
+	timeNow = func() time.Time {
+		t, _ := time.Parse("01/02/2006 15:04:05", "01/01/2020 15:00:00")
+		return t
+	}
+	atomic.StoreInt64(&runBacklog, 0)
+
+	clientset := fake.NewSimpleClientset()
+	pods := []struct {
+		name     string
+		job      string
+		lifetime string
+	}{
+		{"ondemand-job1a", "1", "1h"},
+		{"ondemand-job1b", "1", "1h"},
+		{"ondemand-job2", "2", "90m"},
+		{"ondemand-job3", "3", "100m"},
+	}
+	for _, p := range pods {
+		pod := &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        p.name,
+				Namespace:   "user-user1",
+				Labels:      map[string]string{"job": p.job},
+				Annotations: map[string]string{"pod.kubernetes.io/lifetime": p.lifetime},
+			},
+			Status: v1.PodStatus{StartTime: &podStartTime},
+		}
+		if _, err := clientset.CoreV1().Pods("user-user1").Create(context.TODO(), pod, metav1.CreateOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	jobs, err := getJobs(context.TODO(), clientset, []string{metav1.NamespaceAll}, logger)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	names := []string{}
+	for _, job := range jobs {
+		names = append(names, job.podName)
+	}
+	sort.Strings(names)
+	if strings.Join(names, ",") != "ondemand-job1a,ondemand-job1b,ondemand-job2" {
+		t.Errorf("Unexpected pods selected, got: %v", names)
+	}
+	if val := atomic.LoadInt64(&runBacklog); val != 1 {
+		t.Errorf("Unexpected backlog, got: %d", val)
+	}
+}
+
+func TestLimitJobObjects(t *testing.T) {
+	atomic.StoreInt64(&runBacklog, 0)
+	jobObjects := []jobObject{
+		{objectType: "pod", jobID: "1"}, {objectType: "service", jobID: "1"}, {objectType: "secret", jobID: "1"},
+		{objectType: "pod", jobID: "2"}, {objectType: "service", jobID: "2"},
+		{objectType: "pod", jobID: "3"}, {objectType: "service", jobID: "3"},
+	}
+	limited := limitJobObjects(jobObjects, 6, log.NewNopLogger())
+	if len(limited) != 5 || limited[4].jobID != "2" {
+		t.Errorf("Unexpected objects, got: %v", limited)
+	}
+	if val := atomic.LoadInt64(&runBacklog); val != 1 {
+		t.Errorf("Unexpected backlog, got: %d", val)
+	}
+	limited = limitJobObjects(jobObjects, 2, log.NewNopLogger())
+	if len(limited) != 3 || limited[2].jobID != "1" {
+		t.Errorf("Expected first job to be reaped when larger than max, got: %v", limited)
+	}
+	if len(limitJobObjects(jobObjects, 0, log.NewNopLogger())) != len(jobObjects) {
+		t.Errorf("Expected no limit when max is 0")
+	}
+}
+
+func TestGetJobObjectsGroupsPodsByJob(t *testing.T) {
+	if _, err := kingpin.CommandLine.Parse([]string{"--reap-max-objects=3"}); err != nil {
+		t.Fatal(err)
+	}
+	atomic.StoreInt64(&runBacklog, 0)
+	clientset := fake.NewSimpleClientset(
+		&v1.Service{ObjectMeta: metav1.ObjectMeta{Name: "ondemand-job1", Namespace: "user-user1", Labels: map[string]string{"job": "1"}}},
+		&v1.Service{ObjectMeta: metav1.ObjectMeta{Name: "ondemand-job2", Namespace: "user-user1", Labels: map[string]string{"job": "2"}}},
+	)
+	jobs := []podJob{
+		{jobID: "1", podName: "ondemand-job1a", namespace: "user-user1", reason: reasonLifetime},
+		{jobID: "2", podName: "ondemand-job2", namespace: "user-user1", reason: reasonLifetime},
+		{jobID: "1", podName: "ondemand-job1b", namespace: "user-user1", reason: reasonLifetime},
+	}
+	jobObjects, err := getJobObjects(context.TODO(), clientset, jobs, log.NewNopLogger())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	names := []string{}
+	for _, jobObject := range jobObjects {
+		names = append(names, jobObject.objectType+"/"+jobObject.name)
+	}
+	if strings.Join(names, ",") != "pod/ondemand-job1a,pod/ondemand-job1b,service/ondemand-job1,pod/ondemand-job2,service/ondemand-job2" {
+		t.Errorf("Unexpected job objects, got: %v", names)
+	}
+	serviceLists := 0
+	for _, action := range clientset.Actions() {
+		if action.GetVerb() == "list" && action.GetResource().Resource == "services" {
+			serviceLists++
+		}
+	}
+	if serviceLists != 2 {
+		t.Errorf("Expected services to be listed once per job, got %d lists", serviceLists)
+	}
+	if groups := groupJobObjects(jobObjects); len(groups) != 2 {
+		t.Errorf("Unexpected number of jobs, got: %d", len(groups))
+	}
+	limited := limitJobObjects(jobObjects, *reapMaxObjects, log.NewNopLogger())
+	if len(limited) != 3 || limited[1].name != "ondemand-job1b" {
+		t.Errorf("Expected every pod of the first job to be reaped, got: %v", limited)
+	}
+	if val := atomic.LoadInt64(&runBacklog); val != 1 {
+		t.Errorf("Unexpected backlog, got: %d", val)
+	}
+}
+
+func TestReapRelatedKinds(t *testing.T) {
+	if _, err := kingpin.CommandLine.Parse([]string{"--reap-persistentvolumeclaims", "--reap-ingresses", "--reap-rolebindings"}); err != nil {
+		t.Fatal(err)
+	}
+	w := log.NewSyncWriter(os.Stderr)
+	logger := log.NewLogfmtLogger(w)
+
+	meta := metav1.ObjectMeta{
+		Name:      "ondemand-job1",
+		Namespace: "user-user1",
+		Labels:    map[string]string{"job": "1"},
+	}
+	clientset := fake.NewSimpleClientset(
+		&v1.Pod{ObjectMeta: meta},
+		&v1.PersistentVolumeClaim{ObjectMeta: meta},
+		&networkingv1.Ingress{ObjectMeta: meta},
+		&networkingv1.NetworkPolicy{ObjectMeta: meta},
+		&rbacv1.RoleBinding{ObjectMeta: meta},
+		&v1.Endpoints{ObjectMeta: meta},
+	)
+	jobs := []podJob{{jobID: "1", podName: "ondemand-job1", namespace: "user-user1", reason: reasonLifetime}}
+	jobObjects, err := getJobObjects(context.TODO(), clientset, jobs, logger)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	types := []string{}
+	for _, jobObject := range jobObjects {
+		types = append(types, jobObject.objectType)
+	}
+	if strings.Join(types, ",") != "pod,persistentvolumeclaim,ingress,rolebinding" {
+		t.Errorf("Unexpected job objects, got: %v", types)
+	}
+	if err := reap(context.TODO(), clientset, jobObjects, logger); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	pvcs, _ := clientset.CoreV1().PersistentVolumeClaims("user-user1").List(context.TODO(), metav1.ListOptions{})
+	ingresses, _ := clientset.NetworkingV1().Ingresses("user-user1").List(context.TODO(), metav1.ListOptions{})
+	roleBindings, _ := clientset.RbacV1().RoleBindings("user-user1").List(context.TODO(), metav1.ListOptions{})
+	if len(pvcs.Items) != 0 || len(ingresses.Items) != 0 || len(roleBindings.Items) != 0 {
+		t.Errorf("Expected enabled kinds to be reaped, got: %d %d %d", len(pvcs.Items), len(ingresses.Items), len(roleBindings.Items))
+	}
+	networkPolicies, _ := clientset.NetworkingV1().NetworkPolicies("user-user1").List(context.TODO(), metav1.ListOptions{})
+	endpoints, _ := clientset.CoreV1().Endpoints("user-user1").List(context.TODO(), metav1.ListOptions{})
+	if len(networkPolicies.Items) != 1 || len(endpoints.Items) != 1 {
+		t.Errorf("Expected kinds not enabled to remain, got: %d %d", len(networkPolicies.Items), len(endpoints.Items))
+	}
+}
+
+func TestListPages(t *testing.T) {
+	if _, err := kingpin.CommandLine.Parse([]string{"--list-page-size=2"}); err != nil {
+		t.Fatal(err)
+	}
+	pages := map[string]string{"": "page2", "page2": "page3", "page3": ""}
+	listed := []string{}
+	err := listPages(context.TODO(), log.NewNopLogger(), metav1.ListOptions{LabelSelector: "job=1"}, func(options metav1.ListOptions) (string, bool, error) {
+		if options.Limit != 2 || options.LabelSelector != "job=1" {
+			t.Errorf("Unexpected list options: %v", options)
+		}
+		listed = append(listed, options.Continue)
+		return pages[options.Continue], true, nil
+	})
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if strings.Join(listed, ",") != ",page2,page3" {
+		t.Errorf("Unexpected pages listed, got: %v", listed)
+	}
+}
+
+// pagedClientset serves pods a page of --list-page-size at a time and records the
+// options of each List call since the fake clientset ignores Limit and Continue
+type pagedClientset struct {
+	*fake.Clientset
+	pods  []v1.Pod
+	lists []metav1.ListOptions
+}
+
+func (c *pagedClientset) CoreV1() typedcorev1.CoreV1Interface {
+	return &pagedCoreV1{CoreV1Interface: c.Clientset.CoreV1(), clientset: c}
+}
+
+type pagedCoreV1 struct {
+	typedcorev1.CoreV1Interface
+	clientset *pagedClientset
+}
+
+func (c *pagedCoreV1) Pods(namespace string) typedcorev1.PodInterface {
+	return &pagedPods{PodInterface: c.CoreV1Interface.Pods(namespace), clientset: c.clientset}
+}
+
+type pagedPods struct {
+	typedcorev1.PodInterface
+	clientset *pagedClientset
+}
+
+func (p *pagedPods) List(ctx context.Context, options metav1.ListOptions) (*v1.PodList, error) {
+	p.clientset.lists = append(p.clientset.lists, options)
+	start := 0
+	if options.Continue != "" {
+		start, _ = strconv.Atoi(options.Continue)
+	}
+	end := len(p.clientset.pods)
+	if options.Limit > 0 && start+int(options.Limit) < end {
+		end = start + int(options.Limit)
+	}
+	list := &v1.PodList{Items: p.clientset.pods[start:end]}
+	if end < len(p.clientset.pods) {
+		list.Continue = strconv.Itoa(end)
+	}
+	return list, nil
+}
+
+func TestGetJobsReapMaxFirst(t *testing.T) {
+	if _, err := kingpin.CommandLine.Parse([]string{"--reap-max=1", "--list-page-size=1"}); err != nil {
+		t.Fatal(err)
+	}
+	w := log.NewSyncWriter(os.Stderr)
+	logger := log.NewLogfmtLogger(w)
+
+	labels := ""
+	podsLabels = &labels
+	timeNow = func() time.Time {
+		t, _ := time.Parse("01/02/2006 15:04:05", "01/01/2020 15:00:00")
+		return t
+	}
+	atomic.StoreInt64(&runBacklog, 0)
+	atomic.StoreInt32(&runBacklogUnknown, 0)
+	defer atomic.StoreInt32(&runBacklogUnknown, 0)
+	metricTrackedPods.Set(5)
+
+	pods := []v1.Pod{}
+	for _, name := range []string{"ondemand-job1", "ondemand-job2", "ondemand-job3"} {
+		pods = append(pods, v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        name,
+				Namespace:   "user-user1",
+				Annotations: map[string]string{"pod.kubernetes.io/lifetime": "1h"},
+			},
+			Status: v1.PodStatus{StartTime: &podStartTime},
+		})
+	}
+	clientset := &pagedClientset{Clientset: fake.NewSimpleClientset(), pods: pods}
+	jobs, err := getJobs(context.TODO(), clientset, []string{metav1.NamespaceAll}, logger)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(jobs) != 1 {
+		t.Errorf("Unexpected number of jobs, got: %d", len(jobs))
+	}
+	for _, options := range clientset.lists {
+		if options.Limit != 1 {
+			t.Errorf("Unexpected limit, got: %d", options.Limit)
+		}
+	}
+	lists := len(clientset.lists)
+	if lists != 1 {
+		t.Errorf("Expected listing to stop once reap-max is reached, got %d lists", lists)
+	}
+	if val := testutil.ToFloat64(metricTrackedPods); val != 5 {
+		t.Errorf("Expected tracked pods to be left unchanged, got: %v", val)
+	}
+	if val := backlogSummary(); val != "unknown" {
+		t.Errorf("Expected unknown backlog, got: %v", val)
+	}
+}
+
+func TestGetJobsWarningWindow(t *testing.T) {
+	if _, err := kingpin.CommandLine.Parse([]string{"--warning-window=15m"}); err != nil {
+		t.Fatal(err)
+	}
+	w := log.NewSyncWriter(os.Stderr)
+	logger := log.NewLogfmtLogger(w)
+
+	fakeRecorder := record.NewFakeRecorder(10)
+	recorder = fakeRecorder
+	defer func() { recorder = nil }()
+
+	labels := "app.kubernetes.io/managed-by=open-ondemand"
+	podsLabels = &labels
+
+	timeNow = func() time.Time {
+		t, _ := time.Parse("01/02/2006 15:04:05", "01/01/2020 13:50:00")
+		return t
+	}
+
+	clientset := fake.NewSimpleClientset(&v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "ondemand-job1",
+			Namespace: "user-user1",
+			Annotations: map[string]string{
+				"pod.kubernetes.io/lifetime": "1h",
+			},
+			Labels: map[string]string{
+				"job":                          "1",
+				"app.kubernetes.io/managed-by": "open-ondemand",
+			},
+		},
+		Status: v1.PodStatus{
+			StartTime: &podStartTime,
+		},
+	}, &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "ondemand-job2",
+			Namespace: "user-user2",
+			Annotations: map[string]string{
+				"pod.kubernetes.io/lifetime": "2h",
+			},
+			Labels: map[string]string{
+				"job":                          "2",
+				"app.kubernetes.io/managed-by": "open-ondemand",
+			},
+		},
+		Status: v1.PodStatus{
+			StartTime: &podStartTime,
+		},
+	})
+
+	jobs, err := getJobs(context.TODO(), clientset, []string{metav1.NamespaceAll}, logger)
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if len(jobs) != 0 {
+		t.Errorf("Expected 0 jobs, got %d", len(jobs))
+	}
+	pod, err := clientset.CoreV1().Pods("user-user1").Get(context.TODO(), "ondemand-job1", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Unexpected error getting pod: %v", err)
+	}
+	if val := pod.Annotations[reapAtAnnotation]; val != "2020-01-01T14:00:00Z" {
+		t.Errorf("Unexpected reap-at annotation, got: %v", val)
+	}
+	pod, err = clientset.CoreV1().Pods("user-user2").Get(context.TODO(), "ondemand-job2", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Unexpected error getting pod: %v", err)
+	}
+	if val, ok := pod.Annotations[reapAtAnnotation]; ok {
+		t.Errorf("Unexpected reap-at annotation, got: %v", val)
+	}
+	if len(fakeRecorder.Events) != 1 {
+		t.Fatalf("Unexpected number of events, got: %d", len(fakeRecorder.Events))
+	}
+	if event := <-fakeRecorder.Events; event != "Warning ExpiringSoon Pod will be reaped at 2020-01-01T14:00:00Z when it exceeds its lifetime" {
+		t.Errorf("Unexpected event, got: %s", event)
+	}
+}
+
+func TestPodLifetimeExtension(t *testing.T) {
+	if _, err := kingpin.CommandLine.Parse([]string{"--max-lifetime=6h"}); err != nil {
+		t.Fatal(err)
+	}
+	w := log.NewSyncWriter(os.Stderr)
+	logger := log.NewLogfmtLogger(w)
+
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "ondemand-job1",
+			Namespace: "user-user1",
+			Annotations: map[string]string{
+				"pod.kubernetes.io/lifetime":           "1h",
+				"pod.kubernetes.io/lifetime-extension": "4h,2h,30m,foo",
+			},
+		},
+	}
+	lifetime, ok := podLifetime(pod, logger)
+	if !ok {
+		t.Fatal("Expected pod to have a lifetime")
+	}
+	if lifetime != 5*time.Hour+30*time.Minute {
+		t.Errorf("Unexpected lifetime, got: %v", lifetime)
+	}
+
+	if _, err := kingpin.CommandLine.Parse([]string{"--max-extensions=1"}); err != nil {
+		t.Fatal(err)
+	}
+	lifetime, _ = podLifetime(pod, logger)
+	if lifetime != 5*time.Hour {
+		t.Errorf("Unexpected lifetime, got: %v", lifetime)
+	}
+
+	if _, err := kingpin.CommandLine.Parse([]string{"--max-lifetime=30m"}); err != nil {
+		t.Fatal(err)
+	}
+	lifetime, _ = podLifetime(pod, logger)
+	if lifetime != 30*time.Minute {
+		t.Errorf("Unexpected lifetime, got: %v", lifetime)
+	}
+}
+
+func TestPodExpiry(t *testing.T) {
+	if _, err := kingpin.CommandLine.Parse([]string{}); err != nil {
+		t.Fatal(err)
+	}
+	w := log.NewSyncWriter(os.Stderr)
+	logger := log.NewLogfmtLogger(w)
+
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "ondemand-job1",
+			Namespace: "user-user1",
+			Annotations: map[string]string{
+				"pod.kubernetes.io/lifetime": "1h",
+			},
+		},
+		Status: v1.PodStatus{
+			StartTime: &podStartTime,
+		},
+	}
+	expiry, rule, ok := podExpiry(pod, logger)
+	if !ok {
+		t.Fatal("Expected pod to have an expiry")
+	}
+	if expected := podStart.Add(time.Hour); !expiry.Equal(expected) {
+		t.Errorf("Unexpected expiry, got: %v expected: %v", expiry, expected)
+	}
+	if rule != reasonLifetime {
+		t.Errorf("Unexpected rule, got: %v", rule)
+	}
+	pod.Annotations[expiresAtAnnotation] = "2020-01-01T13:30:00Z"
+	expiry, rule, _ = podExpiry(pod, logger)
+	if expected := podStart.Add(30 * time.Minute); !expiry.Equal(expected) {
+		t.Errorf("Unexpected expiry, got: %v expected: %v", expiry, expected)
+	}
+	if rule != reasonExpiresAt {
+		t.Errorf("Unexpected rule, got: %v", rule)
+	}
+	pod.Annotations[expiresAtAnnotation] = "2020-01-01T15:00:00Z"
+	if _, rule, _ = podExpiry(pod, logger); rule != reasonLifetime {
+		t.Errorf("Unexpected rule, got: %v", rule)
+	}
+	delete(pod.Annotations, lifetimeAnnotation)
+	pod.Annotations[expiresAtAnnotation] = "2030-01-01T15:00:00Z"
+	if _, err := kingpin.CommandLine.Parse([]string{"--max-lifetime=2h"}); err != nil {
+		t.Fatal(err)
+	}
+	expiry, rule, _ = podExpiry(pod, logger)
+	if expected := podStart.Add(2 * time.Hour); !expiry.Equal(expected) || rule != reasonExpiresAt {
+		t.Errorf("Expected expires-at to be limited by max lifetime, got: %v %v expected: %v", expiry, rule, expected)
+	}
+	namespacePolicies.set(map[string]namespacePolicy{"user-user1": {maxLifetime: time.Hour}})
+	expiry, _, _ = podExpiry(pod, logger)
+	namespacePolicies.set(make(map[string]namespacePolicy))
+	if expected := podStart.Add(time.Hour); !expiry.Equal(expected) {
+		t.Errorf("Expected expires-at to be limited by namespace max lifetime, got: %v expected: %v", expiry, expected)
+	}
+	if _, err := kingpin.CommandLine.Parse([]string{}); err != nil {
+		t.Fatal(err)
+	}
+	pod.Annotations[lifetimeAnnotation] = "1h"
+	delete(pod.Annotations, expiresAtAnnotation)
+	pod.Status.StartTime = nil
+	if expiry, _, ok := podExpiry(pod, logger); !ok || !expiry.IsZero() {
+		t.Errorf("Expected pod without start time to have no expiry")
+	}
+	delete(pod.Annotations, lifetimeAnnotation)
+	if _, _, ok := podExpiry(pod, logger); ok {
+		t.Errorf("Expected pod without annotations to not be tracked")
+	}
+}
+
+func TestGetJobsInvalidLifetime(t *testing.T) {
+	if _, err := kingpin.CommandLine.Parse([]string{}); err != nil {
+		t.Fatal(err)
+	}
+	w := log.NewSyncWriter(os.Stderr)
+	logger := log.NewLogfmtLogger(w)
+
+	fakeRecorder := record.NewFakeRecorder(10)
+	recorder = fakeRecorder
+	defer func() { recorder = nil }()
+
+	labels := "app.kubernetes.io/managed-by=open-ondemand"
+	podsLabels = &labels
+
+	timeNow = func() time.Time {
+		t, _ := time.Parse("01/02/2006 15:04:05", "01/03/2020 15:00:00")
+		return t
+	}
+
+	clientset := fake.NewSimpleClientset(&v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "ondemand-job1",
+			Namespace: "user-user1",
+			Annotations: map[string]string{
+				"pod.kubernetes.io/lifetime": "1d",
+			},
+			Labels: map[string]string{
+				"job":                          "1",
+				"app.kubernetes.io/managed-by": "open-ondemand",
+			},
+		},
+		Status: v1.PodStatus{
+			StartTime: &podStartTime,
+		},
+	}, &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "ondemand-job2",
+			Namespace: "user-user2",
+			Annotations: map[string]string{
+				"pod.kubernetes.io/lifetime": "1 day",
+			},
+			Labels: map[string]string{
+				"job":                          "2",
+				"app.kubernetes.io/managed-by": "open-ondemand",
+			},
+		},
+		Status: v1.PodStatus{
+			StartTime: &podStartTime,
+		},
+	})
+
+	jobs, err := getJobs(context.TODO(), clientset, []string{metav1.NamespaceAll}, logger)
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if len(jobs) != 1 {
+		t.Fatalf("Expected 1 jobs, got %d", len(jobs))
+	}
+	if val := jobs[0].jobID; val != "1" {
+		t.Errorf("Unexpected jobID, got: %v", val)
+	}
+	if len(fakeRecorder.Events) != 1 {
+		t.Fatalf("Unexpected number of events, got: %d", len(fakeRecorder.Events))
+	}
+	if event := <-fakeRecorder.Events; !strings.HasPrefix(event, "Warning InvalidAnnotation Unable to parse annotation pod.kubernetes.io/lifetime=\"1 day\"") {
+		t.Errorf("Unexpected event, got: %s", event)
+	}
+}
+
+func TestGetJobsNamespacePolicy(t *testing.T) {
+	if _, err := kingpin.CommandLine.Parse([]string{}); err != nil {
+		t.Fatal(err)
+	}
+	w := log.NewSyncWriter(os.Stderr)
+	logger := log.NewLogfmtLogger(w)
+	defer namespacePolicies.set(make(map[string]namespacePolicy))
+
+	labels := "app.kubernetes.io/managed-by=open-ondemand"
+	podsLabels = &labels
+
+	timeNow = func() time.Time {
+		t, _ := time.Parse("01/02/2006 15:04:05", "01/01/2020 15:30:00")
+		return t
+	}
+
+	clientset := fake.NewSimpleClientset(&v1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "user-policy",
+			Annotations: map[string]string{
+				"job-pod-reaper/default-lifetime": "2h",
+				"job-pod-reaper/max-lifetime":     "3h",
+			},
+		},
+	}, &v1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "user-nopolicy",
+		},
+	}, &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "default-lifetime",
+			Namespace: "user-policy",
+			Labels: map[string]string{
+				"job":                          "1",
+				"app.kubernetes.io/managed-by": "open-ondemand",
+			},
+		},
+		Status: v1.PodStatus{
+			StartTime: &podStartTime,
+		},
+	}, &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "max-lifetime",
+			Namespace: "user-policy",
+			Annotations: map[string]string{
+				"pod.kubernetes.io/lifetime": "1d",
+			},
+			Labels: map[string]string{
+				"job":                          "2",
+				"app.kubernetes.io/managed-by": "open-ondemand",
+			},
+		},
+		Status: v1.PodStatus{
+			StartTime: &podStartTime,
+		},
+	}, &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "no-lifetime",
+			Namespace: "user-nopolicy",
+			Labels: map[string]string{
+				"job":                          "3",
+				"app.kubernetes.io/managed-by": "open-ondemand",
+			},
+		},
+		Status: v1.PodStatus{
+			StartTime: &podStartTime,
+		},
+	}, &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "long-lifetime",
+			Namespace: "user-nopolicy",
+			Annotations: map[string]string{
+				"pod.kubernetes.io/lifetime": "1d",
+			},
+			Labels: map[string]string{
+				"job":                          "4",
+				"app.kubernetes.io/managed-by": "open-ondemand",
+			},
+		},
+		Status: v1.PodStatus{
+			StartTime: &podStartTime,
+		},
+	})
+
+	namespaces, err := getNamespaces(context.TODO(), clientset, logger)
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	jobs, err := getJobs(context.TODO(), clientset, namespaces, logger)
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if len(jobs) != 1 {
+		t.Fatalf("Expected 1 jobs, got %d", len(jobs))
+	}
+	if val := jobs[0].jobID; val != "1" {
+		t.Errorf("Unexpected jobID, got: %v", val)
+	}
+	if val := jobs[0].lifetime; val != 2*time.Hour {
+		t.Errorf("Unexpected lifetime, got: %v", val)
+	}
+
+	timeNow = func() time.Time {
+		t, _ := time.Parse("01/02/2006 15:04:05", "01/01/2020 16:30:00")
+		return t
+	}
+	jobs, err = getJobs(context.TODO(), clientset, namespaces, logger)
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if len(jobs) != 2 {
+		t.Fatalf("Expected 2 jobs, got %d", len(jobs))
+	}
+	if val := jobs[1].jobID; val != "2" {
+		t.Errorf("Unexpected jobID, got: %v", val)
+	}
+	if val := jobs[1].lifetime; val != 3*time.Hour {
+		t.Errorf("Unexpected lifetime, got: %v", val)
+	}
+}
+
+func TestValidateFlags(t *testing.T) {
+	tests := []struct {
+		args  []string
+		valid bool
+	}{
+		{[]string{}, true},
+		{[]string{"--watch"}, true},
+		{[]string{"--watch", "--run-once"}, false},
+		{[]string{"--watch", "--reaper-policies"}, false},
+		{[]string{"--orphan-grace-period=1h"}, true},
+		{[]string{"--run-once", "--orphan-grace-period=1h"}, false},
+		{[]string{"--reap-workers=0"}, false},
+	}
+	for _, test := range tests {
+		if _, err := kingpin.CommandLine.Parse(test.args); err != nil {
+			t.Fatal(err)
+		}
+		if err := validateFlags(); (err == nil) != test.valid {
+			t.Errorf("Unexpected validation result for %v, got: %v", test.args, err)
+		}
+	}
 }
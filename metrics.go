@@ -0,0 +1,107 @@
+// Copyright 2020 Ohio Supercomputer Center
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/http"
+	"os"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	metricsNamespace = "job_pod_reaper"
+)
+
+var (
+	metricDeleted = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "deleted_total",
+		Help:      "Total number of objects deleted by the reaper",
+	}, []string{"type", "namespace", "reason"})
+	metricErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "errors_total",
+		Help:      "Total number of errors deleting objects",
+	}, []string{"type", "namespace"})
+	metricDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "duration_seconds",
+		Help:      "Duration of each phase of a reaper run",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"phase"})
+	metricLastSuccess = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "last_success_timestamp_seconds",
+		Help:      "Unix timestamp of the last successful reaper run",
+	})
+	metricTrackedPods = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "tracked_pods",
+		Help:      "Number of pods with a lifetime seen during the last run",
+	})
+	metricBacklog = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "backlog_jobs",
+		Help:      "Number of jobs that could be reaped but were left for a later run by --reap-max or --reap-max-objects, NaN when unknown",
+	})
+	metricLeader = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "leader",
+		Help:      "Whether this replica holds the leader election lease",
+	})
+	metricConfigLastReloadSuccess = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "config_last_reload_successful",
+		Help:      "Whether the last load of the configuration file was successful",
+	})
+	metricConfigReloadFailures = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "config_reload_failures_total",
+		Help:      "Total number of failures loading the configuration file",
+	})
+	metricWebhookRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "webhook_requests_total",
+		Help:      "Total number of admission webhook requests",
+	}, []string{"webhook", "allowed"})
+)
+
+func init() {
+	prometheus.MustRegister(metricDeleted, metricErrors, metricDuration, metricLastSuccess, metricTrackedPods, metricBacklog, metricLeader,
+		metricConfigLastReloadSuccess, metricConfigReloadFailures, metricWebhookRequests)
+}
+
+func metricsServer(logger log.Logger) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		//nolint:errcheck
+		w.Write([]byte(`<html>
+             <head><title>job-pod-reaper</title></head>
+             <body>
+             <h1>job-pod-reaper</h1>
+             <p><a href='/metrics'>Metrics</a></p>
+             </body>
+             </html>`))
+	})
+	level.Info(logger).Log("msg", "Listening for metrics", "address", *listenAddress)
+	if err := http.ListenAndServe(*listenAddress, mux); err != nil {
+		level.Error(logger).Log("msg", "Error starting metrics server", "err", err)
+		os.Exit(1)
+	}
+}
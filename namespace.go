@@ -0,0 +1,125 @@
+// Copyright 2020 Ohio Supercomputer Center
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	v1 "k8s.io/api/core/v1"
+)
+
+const (
+	namespaceDefaultLifetimeAnnotation string = "job-pod-reaper/default-lifetime"
+	namespaceMaxLifetimeAnnotation     string = "job-pod-reaper/max-lifetime"
+)
+
+var (
+	namespacePolicies = &namespacePolicyStore{policies: make(map[string]namespacePolicy)}
+)
+
+// namespacePolicy holds the policy defined by namespace annotations
+// and the namespace overrides of the configuration file
+type namespacePolicy struct {
+	defaultLifetime time.Duration
+	maxLifetime     time.Duration
+	reapEvictedPods *bool
+}
+
+func (p namespacePolicy) empty() bool {
+	return p.defaultLifetime == 0 && p.maxLifetime == 0 && p.reapEvictedPods == nil
+}
+
+type namespacePolicyStore struct {
+	sync.RWMutex
+	policies  map[string]namespacePolicy
+	overrides map[string]namespacePolicy
+}
+
+func (s *namespacePolicyStore) get(namespace string) namespacePolicy {
+	s.RLock()
+	defer s.RUnlock()
+	return s.policies[namespace]
+}
+
+func (s *namespacePolicyStore) set(policies map[string]namespacePolicy) {
+	s.Lock()
+	defer s.Unlock()
+	s.policies = policies
+}
+
+// getOverrides returns a copy of the configuration file namespace overrides
+func (s *namespacePolicyStore) getOverrides() map[string]namespacePolicy {
+	s.RLock()
+	defer s.RUnlock()
+	overrides := make(map[string]namespacePolicy)
+	for namespace, policy := range s.overrides {
+		overrides[namespace] = policy
+	}
+	return overrides
+}
+
+func (s *namespacePolicyStore) setOverrides(overrides map[string]namespacePolicy) {
+	s.Lock()
+	defer s.Unlock()
+	s.overrides = overrides
+}
+
+// maxLifetimeFor returns the smaller of --max-lifetime and the namespace max lifetime
+func maxLifetimeFor(namespace string) time.Duration {
+	max := *maxLifetime
+	policy := namespacePolicies.get(namespace)
+	if policy.maxLifetime != 0 && (max == 0 || policy.maxLifetime < max) {
+		max = policy.maxLifetime
+	}
+	return max
+}
+
+// reapEvictedFor returns whether evicted pods are reaped in the namespace
+func reapEvictedFor(namespace string) bool {
+	if policy := namespacePolicies.get(namespace); policy.reapEvictedPods != nil {
+		return *policy.reapEvictedPods
+	}
+	return *reapEvictedPods
+}
+
+// getNamespacePolicy returns policy with the values set by the namespace annotations replaced
+func getNamespacePolicy(namespace *v1.Namespace, policy namespacePolicy, logger log.Logger) namespacePolicy {
+	nsLogger := log.With(logger, "namespace", namespace.Name)
+	annotations := map[string]*time.Duration{
+		namespaceDefaultLifetimeAnnotation: &policy.defaultLifetime,
+		namespaceMaxLifetimeAnnotation:     &policy.maxLifetime,
+	}
+	for annotation, value := range annotations {
+		val, ok := namespace.Annotations[annotation]
+		if !ok {
+			continue
+		}
+		lifetime, err := parseLifetime(val)
+		if err != nil {
+			level.Error(nsLogger).Log("msg", "Error parsing namespace annotation, ignoring", "annotation", annotation, "value", val, "err", err)
+			if recorder != nil && *dryRun == dryRunNone {
+				recorder.Eventf(namespace, v1.EventTypeWarning, eventReasonInvalid, "Unable to parse annotation %s=%q: %v", annotation, val, err)
+			}
+			continue
+		}
+		*value = lifetime
+	}
+	if !policy.empty() {
+		level.Debug(nsLogger).Log("msg", "Namespace lifetime policy", "default", policy.defaultLifetime, "max", policy.maxLifetime)
+	}
+	return policy
+}
@@ -0,0 +1,98 @@
+// Copyright 2020 Ohio Supercomputer Center
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes"
+)
+
+var (
+	workflowResource = schema.GroupVersionResource{Group: "argoproj.io", Version: "v1alpha1", Resource: "workflows"}
+	// ownerObjectTypes are the types of controllers deleted instead of their pods
+	ownerObjectTypes = []string{"job", "deployment", "statefulset", "replicaset", "workflow"}
+	propagationValid = []string{string(metav1.DeletePropagationBackground), string(metav1.DeletePropagationForeground), string(metav1.DeletePropagationOrphan)}
+)
+
+// controllerOf returns the owner reference of the controller of an object
+func controllerOf(refs []metav1.OwnerReference) *metav1.OwnerReference {
+	for i := range refs {
+		if refs[i].Controller != nil && *refs[i].Controller {
+			return &refs[i]
+		}
+	}
+	return nil
+}
+
+// ownerObjectType returns the object type of a controller the reaper can delete
+func ownerObjectType(ref *metav1.OwnerReference) (string, bool) {
+	refGV, err := schema.ParseGroupVersion(ref.APIVersion)
+	if err != nil {
+		return "", false
+	}
+	for _, objectType := range ownerObjectTypes {
+		kind := objectKinds[objectType]
+		gv, _ := schema.ParseGroupVersion(kind.apiVersion)
+		if kind.kind == ref.Kind && gv.Group == refGV.Group {
+			return objectType, true
+		}
+	}
+	return "", false
+}
+
+// topController walks up the controllers of a pod to the top-level Job, Deployment,
+// StatefulSet, ReplicaSet or Argo Workflow and returns it, nil if the pod has no such controller
+func topController(ctx context.Context, clientset kubernetes.Interface, job podJob, logger log.Logger) (*jobObject, error) {
+	var owner *jobObject
+	ref := controllerOf(job.owners)
+	for ref != nil {
+		objectType, ok := ownerObjectType(ref)
+		if !ok {
+			break
+		}
+		owner = &jobObject{objectType: objectType, jobID: job.jobID, name: ref.Name, namespace: job.namespace,
+			reason: job.reason, age: job.age, lifetime: job.lifetime, policy: job.policy}
+		if objectType != "replicaset" {
+			break
+		}
+		// ReplicaSets are usually owned by a Deployment
+		var refs []metav1.OwnerReference
+		err := withRetry(ctx, logger, func() error {
+			replicaSet, err := clientset.AppsV1().ReplicaSets(job.namespace).Get(ctx, ref.Name, metav1.GetOptions{})
+			if err != nil {
+				return err
+			}
+			refs = replicaSet.OwnerReferences
+			return nil
+		})
+		if apierrors.IsNotFound(err) {
+			break
+		}
+		if err != nil {
+			level.Error(logger).Log("msg", errorMessage("Error getting replicaset", err), "name", ref.Name, "err", err)
+			return nil, err
+		}
+		ref = controllerOf(refs)
+	}
+	if owner != nil {
+		level.Debug(logger).Log("msg", "Pod has top-level controller", "type", owner.objectType, "name", owner.name)
+	}
+	return owner, nil
+}
@@ -0,0 +1,177 @@
+// Copyright 2020 Ohio Supercomputer Center
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	typedbatchv1 "k8s.io/client-go/kubernetes/typed/batch/v1"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+func controllerRef(apiVersion string, kind string, name string) []metav1.OwnerReference {
+	isController := true
+	return []metav1.OwnerReference{{APIVersion: apiVersion, Kind: kind, Name: name, Controller: &isController}}
+}
+
+func TestTopController(t *testing.T) {
+	clientset := fake.NewSimpleClientset(&appsv1.ReplicaSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "ondemand-abc",
+			Namespace:       "user-user1",
+			OwnerReferences: controllerRef("apps/v1", "Deployment", "ondemand"),
+		},
+	}, &appsv1.ReplicaSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "standalone",
+			Namespace: "user-user1",
+		},
+	})
+	tests := []struct {
+		owners     []metav1.OwnerReference
+		objectType string
+		name       string
+	}{
+		{controllerRef("apps/v1", "ReplicaSet", "ondemand-abc"), "deployment", "ondemand"},
+		{controllerRef("apps/v1", "ReplicaSet", "standalone"), "replicaset", "standalone"},
+		{controllerRef("apps/v1", "ReplicaSet", "missing"), "replicaset", "missing"},
+		{controllerRef("batch/v1", "Job", "ondemand-job1"), "job", "ondemand-job1"},
+		{controllerRef("apps/v1", "StatefulSet", "ondemand"), "statefulset", "ondemand"},
+		{controllerRef("argoproj.io/v1alpha1", "Workflow", "workflow1"), "workflow", "workflow1"},
+		{controllerRef("apps/v1", "DaemonSet", "ondemand"), "", ""},
+		{controllerRef("example.com/v1", "Job", "ondemand"), "", ""},
+		{nil, "", ""},
+	}
+	for _, test := range tests {
+		job := podJob{jobID: "1", podName: "ondemand-job1", namespace: "user-user1", owners: test.owners}
+		owner, err := topController(context.TODO(), clientset, job, log.NewNopLogger())
+		if err != nil {
+			t.Errorf("Unexpected error for %v: %v", test.owners, err)
+			continue
+		}
+		if test.objectType == "" {
+			if owner != nil {
+				t.Errorf("Expected no controller for %v, got: %v", test.owners, owner)
+			}
+			continue
+		}
+		if owner == nil || owner.objectType != test.objectType || owner.name != test.name {
+			t.Errorf("Unexpected controller for %v, got: %v", test.owners, owner)
+		}
+	}
+}
+
+// jobDeleteClientset records the options of each Job delete since the fake
+// clientset does not pass delete options to its reactors
+type jobDeleteClientset struct {
+	*fake.Clientset
+	deleteOptions []metav1.DeleteOptions
+}
+
+func (c *jobDeleteClientset) BatchV1() typedbatchv1.BatchV1Interface {
+	return &jobDeleteBatchV1{BatchV1Interface: c.Clientset.BatchV1(), clientset: c}
+}
+
+type jobDeleteBatchV1 struct {
+	typedbatchv1.BatchV1Interface
+	clientset *jobDeleteClientset
+}
+
+func (b *jobDeleteBatchV1) Jobs(namespace string) typedbatchv1.JobInterface {
+	return &jobDeleteJobs{JobInterface: b.BatchV1Interface.Jobs(namespace), clientset: b.clientset}
+}
+
+type jobDeleteJobs struct {
+	typedbatchv1.JobInterface
+	clientset *jobDeleteClientset
+}
+
+func (j *jobDeleteJobs) Delete(ctx context.Context, name string, options metav1.DeleteOptions) error {
+	j.clientset.deleteOptions = append(j.clientset.deleteOptions, options)
+	return j.JobInterface.Delete(ctx, name, options)
+}
+
+func TestReapOwners(t *testing.T) {
+	if _, err := kingpin.CommandLine.Parse([]string{"--reap-owners", "--propagation-policy=Foreground"}); err != nil {
+		t.Fatal(err)
+	}
+	w := log.NewSyncWriter(os.Stderr)
+	logger := log.NewLogfmtLogger(w)
+
+	fakeClientset := fake.NewSimpleClientset(&batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "ondemand-job1",
+			Namespace: "user-user1",
+		},
+	}, &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "ondemand-job1-xyz",
+			Namespace:       "user-user1",
+			OwnerReferences: controllerRef("batch/v1", "Job", "ondemand-job1"),
+		},
+	}, &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "ondemand-job1",
+			Namespace: "user-user1",
+			Labels:    map[string]string{"job": "1"},
+		},
+	})
+	deletes := []string{}
+	fakeClientset.PrependReactor("delete", "*", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		deletes = append(deletes, action.GetResource().Resource)
+		return false, nil, nil
+	})
+	clientset := &jobDeleteClientset{Clientset: fakeClientset}
+	jobs := []podJob{{jobID: "1", podName: "ondemand-job1-xyz", namespace: "user-user1", reason: reasonLifetime,
+		owners: controllerRef("batch/v1", "Job", "ondemand-job1")}}
+	jobObjects, err := getJobObjects(context.TODO(), clientset, jobs, logger)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := reap(context.TODO(), clientset, jobObjects, logger); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if len(deletes) != 2 || deletes[0] != "jobs" || deletes[1] != "services" {
+		t.Errorf("Expected job to be deleted instead of its pod, got: %v", deletes)
+	}
+	if len(clientset.deleteOptions) != 1 {
+		t.Fatalf("Unexpected number of job deletes, got: %d", len(clientset.deleteOptions))
+	}
+	if policy := clientset.deleteOptions[0].PropagationPolicy; policy == nil || *policy != metav1.DeletePropagationForeground {
+		t.Errorf("Unexpected propagation policy, got: %v", policy)
+	}
+
+	deletes = []string{}
+	jobs[0].reason = reasonEvicted
+	jobObjects, err = getJobObjects(context.TODO(), clientset, jobs, logger)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := reap(context.TODO(), clientset, jobObjects, logger); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if len(deletes) == 0 || deletes[0] != "pods" {
+		t.Errorf("Expected evicted pod to be deleted instead of its controller, got: %v", deletes)
+	}
+}
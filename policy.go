@@ -0,0 +1,324 @@
+// Copyright 2020 Ohio Supercomputer Center
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+)
+
+const (
+	policyGroup   = "job-pod-reaper.osc.edu"
+	policyVersion = "v1alpha1"
+)
+
+var (
+	reaperPolicyResource        = schema.GroupVersionResource{Group: policyGroup, Version: policyVersion, Resource: "reaperpolicies"}
+	clusterReaperPolicyResource = schema.GroupVersionResource{Group: policyGroup, Version: policyVersion, Resource: "clusterreaperpolicies"}
+	policyClient                dynamic.Interface
+	reaperPolicies              = &reaperPolicyStore{}
+)
+
+// reaperPolicySpec is the spec of the ReaperPolicy and ClusterReaperPolicy resources
+type reaperPolicySpec struct {
+	Selector        *metav1.LabelSelector `json:"selector,omitempty"`
+	Lifetime        string                `json:"lifetime,omitempty"`
+	Timestamp       string                `json:"timestamp,omitempty"`
+	ReapEvictedPods *bool                 `json:"reapEvictedPods,omitempty"`
+	JobLabel        string                `json:"jobLabel,omitempty"`
+	RelatedKinds    []string              `json:"relatedKinds,omitempty"`
+}
+
+type reaperPolicyStatus struct {
+	LastEvaluationTime *metav1.Time `json:"lastEvaluationTime,omitempty"`
+	MatchedPods        int64        `json:"matchedPods"`
+	PodsReaped         int64        `json:"podsReaped"`
+	ObjectsReaped      int64        `json:"objectsReaped"`
+}
+
+type reaperPolicyObject struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              reaperPolicySpec   `json:"spec"`
+	Status            reaperPolicyStatus `json:"status,omitempty"`
+}
+
+// reaperPolicy is a validated ReaperPolicy, namespace is empty for a ClusterReaperPolicy
+type reaperPolicy struct {
+	// counters are updated atomically and first for 64-bit alignment
+	matchedPods   int64
+	podsReaped    int64
+	objectsReaped int64
+
+	name            string
+	namespace       string
+	object          *unstructured.Unstructured
+	status          reaperPolicyStatus
+	selector        labels.Selector
+	lifetime        time.Duration
+	timestamp       string
+	reapEvictedPods *bool
+	jobLabel        string
+	relatedKinds    []string
+}
+
+func (p *reaperPolicy) key() string {
+	if p.namespace == "" {
+		return p.name
+	}
+	return p.namespace + "/" + p.name
+}
+
+func (p *reaperPolicy) resource() dynamic.ResourceInterface {
+	if p.namespace == "" {
+		return policyClient.Resource(clusterReaperPolicyResource)
+	}
+	return policyClient.Resource(reaperPolicyResource).Namespace(p.namespace)
+}
+
+// recordReaped counts an object of the policy that was deleted
+func (p *reaperPolicy) recordReaped(objectType string) {
+	if objectType == "pod" {
+		atomic.AddInt64(&p.podsReaped, 1)
+	}
+	atomic.AddInt64(&p.objectsReaped, 1)
+}
+
+type reaperPolicyStore struct {
+	sync.RWMutex
+	policies []*reaperPolicy
+}
+
+func (s *reaperPolicyStore) list() []*reaperPolicy {
+	s.RLock()
+	defer s.RUnlock()
+	return s.policies
+}
+
+func (s *reaperPolicyStore) set(policies []*reaperPolicy) {
+	s.Lock()
+	defer s.Unlock()
+	s.policies = policies
+}
+
+// match returns the policy for the pod, ReaperPolicies in the pod's namespace
+// take precedence over ClusterReaperPolicies and policies are otherwise ordered by name
+func (s *reaperPolicyStore) match(pod *v1.Pod) *reaperPolicy {
+	for _, policy := range s.list() {
+		if policy.namespace != "" && policy.namespace != pod.Namespace {
+			continue
+		}
+		if policy.selector.Matches(labels.Set(pod.Labels)) {
+			return policy
+		}
+	}
+	return nil
+}
+
+// loadReaperPolicies reads the ReaperPolicy and ClusterReaperPolicy resources,
+// policies that are not valid are logged and ignored. A resource that is not found,
+// such as when its CRD is removed, has no policies while a resource that can not be
+// listed for another reason keeps its last loaded policies so reaping continues.
+func loadReaperPolicies(ctx context.Context, logger log.Logger) {
+	if !*reaperPoliciesEnabled {
+		return
+	}
+	policies := []*reaperPolicy{}
+	for _, gvr := range []schema.GroupVersionResource{reaperPolicyResource, clusterReaperPolicyResource} {
+		list, err := policyClient.Resource(gvr).List(ctx, metav1.ListOptions{})
+		if apierrors.IsNotFound(err) {
+			level.Warn(logger).Log("msg", "Policy resource not found, is the CRD installed?", "resource", gvr.Resource, "err", err)
+			continue
+		}
+		if err != nil {
+			runFailures.record("list", err)
+			level.Warn(logger).Log("msg", errorMessage("Error listing policies, keeping last loaded policies", err), "resource", gvr.Resource, "err", err)
+			cluster := gvr == clusterReaperPolicyResource
+			for _, policy := range reaperPolicies.list() {
+				if (policy.namespace == "") == cluster {
+					atomic.StoreInt64(&policy.matchedPods, 0)
+					policies = append(policies, policy)
+				}
+			}
+			continue
+		}
+		for i := range list.Items {
+			policy, err := newReaperPolicy(&list.Items[i])
+			if err != nil {
+				level.Error(logger).Log("msg", "Invalid policy, ignoring", "resource", gvr.Resource,
+					"name", list.Items[i].GetName(), "namespace", list.Items[i].GetNamespace(), "err", err)
+				continue
+			}
+			policies = append(policies, policy)
+		}
+	}
+	sort.SliceStable(policies, func(i, j int) bool {
+		if (policies[i].namespace == "") != (policies[j].namespace == "") {
+			return policies[i].namespace != ""
+		}
+		return policies[i].key() < policies[j].key()
+	})
+	level.Debug(logger).Log("msg", "Loaded policies", "count", len(policies))
+	reaperPolicies.set(policies)
+}
+
+func newReaperPolicy(obj *unstructured.Unstructured) (*reaperPolicy, error) {
+	policyObject := reaperPolicyObject{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, &policyObject); err != nil {
+		return nil, err
+	}
+	spec := policyObject.Spec
+	policy := &reaperPolicy{
+		name:            obj.GetName(),
+		namespace:       obj.GetNamespace(),
+		object:          obj,
+		status:          policyObject.Status,
+		selector:        labels.Everything(),
+		timestamp:       spec.Timestamp,
+		reapEvictedPods: spec.ReapEvictedPods,
+		jobLabel:        spec.JobLabel,
+		relatedKinds:    spec.RelatedKinds,
+	}
+	if spec.Selector != nil {
+		selector, err := metav1.LabelSelectorAsSelector(spec.Selector)
+		if err != nil {
+			return nil, fmt.Errorf("invalid selector: %v", err)
+		}
+		policy.selector = selector
+	}
+	if spec.Lifetime != "" {
+		lifetime, err := parseLifetime(spec.Lifetime)
+		if err != nil {
+			return nil, err
+		}
+		policy.lifetime = lifetime
+	}
+	if spec.Timestamp != "" && !sliceContains(reapTimestampValid, spec.Timestamp) {
+		return nil, fmt.Errorf("unrecognized timestamp %s", spec.Timestamp)
+	}
+	for _, kind := range policy.relatedKinds {
+		if !sliceContains(relatedObjectTypes, kind) {
+			return nil, fmt.Errorf("unrecognized related kind %s", kind)
+		}
+	}
+	return policy, nil
+}
+
+// updateReaperPolicyStatus adds the pods and objects reaped since the
+// policies were loaded to the status of each policy
+func updateReaperPolicyStatus(ctx context.Context, logger log.Logger) {
+	if !*reaperPoliciesEnabled || *dryRun == dryRunClient {
+		return
+	}
+	updateOptions := metav1.UpdateOptions{}
+	if *dryRun == dryRunServer {
+		updateOptions.DryRun = []string{metav1.DryRunAll}
+	}
+	now := metav1.NewTime(timeNow())
+	for _, policy := range reaperPolicies.list() {
+		status := reaperPolicyStatus{
+			LastEvaluationTime: &now,
+			MatchedPods:        atomic.LoadInt64(&policy.matchedPods),
+			PodsReaped:         policy.status.PodsReaped + atomic.LoadInt64(&policy.podsReaped),
+			ObjectsReaped:      policy.status.ObjectsReaped + atomic.LoadInt64(&policy.objectsReaped),
+		}
+		statusObject, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&status)
+		if err != nil {
+			level.Error(logger).Log("msg", "Error converting policy status", "policy", policy.key(), "err", err)
+			continue
+		}
+		obj := policy.object.DeepCopy()
+		obj.Object["status"] = statusObject
+		if _, err := policy.resource().UpdateStatus(ctx, obj, updateOptions); err != nil {
+			level.Error(logger).Log("msg", "Error updating policy status", "policy", policy.key(), "err", err)
+		}
+	}
+}
+
+// countPolicyMatch counts the pod as matched by its policy for the policy status
+func countPolicyMatch(pod *v1.Pod) {
+	if policy := reaperPolicies.match(pod); policy != nil {
+		atomic.AddInt64(&policy.matchedPods, 1)
+	}
+}
+
+// policyNamespaces returns the namespaces reaped that the policy applies to
+func policyNamespaces(policy *reaperPolicy, namespaces []string) []string {
+	if policy.namespace == "" {
+		return namespaces
+	}
+	if sliceContains(namespaces, metav1.NamespaceAll) || sliceContains(namespaces, policy.namespace) {
+		return []string{policy.namespace}
+	}
+	return nil
+}
+
+// jobLabelFor returns the job label of the pod's policy or --job-label
+func jobLabelFor(policy *reaperPolicy) string {
+	if policy != nil && policy.jobLabel != "" {
+		return policy.jobLabel
+	}
+	return *jobLabel
+}
+
+// relatedKindsFor returns the kinds of objects reaped with the pods of the policy,
+// policies without related kinds use the kinds enabled by flags
+func relatedKindsFor(policy *reaperPolicy) []string {
+	if policy != nil && policy.relatedKinds != nil {
+		return policy.relatedKinds
+	}
+	return defaultRelatedKinds()
+}
+
+// defaultRelatedKinds returns services, configmaps, secrets and the kinds enabled by flags
+func defaultRelatedKinds() []string {
+	kinds := []string{"service", "configmap", "secret"}
+	enabled := map[string]bool{
+		"persistentvolumeclaim": *reapPVCs,
+		"ingress":               *reapIngresses,
+		"networkpolicy":         *reapNetworkPolicies,
+		"serviceaccount":        *reapServiceAccounts,
+		"rolebinding":           *reapRoleBindings,
+		"endpoints":             *reapEndpoints,
+	}
+	for _, kind := range relatedObjectTypes {
+		if enabled[kind] {
+			kinds = append(kinds, kind)
+		}
+	}
+	return kinds
+}
+
+// reapEvictedForPolicy returns whether evicted pods of the policy are reaped
+func reapEvictedForPolicy(policy *reaperPolicy, namespace string) bool {
+	if policy != nil && policy.reapEvictedPods != nil {
+		return *policy.reapEvictedPods
+	}
+	return reapEvictedFor(namespace)
+}
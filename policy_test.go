@@ -0,0 +1,301 @@
+// Copyright 2020 Ohio Supercomputer Center
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+	"sigs.k8s.io/yaml"
+)
+
+func policyScheme() *runtime.Scheme {
+	scheme := runtime.NewScheme()
+	for _, kind := range []string{"ReaperPolicy", "ClusterReaperPolicy"} {
+		gv := schema.GroupVersion{Group: policyGroup, Version: policyVersion}
+		scheme.AddKnownTypeWithName(gv.WithKind(kind), &unstructured.Unstructured{})
+		scheme.AddKnownTypeWithName(gv.WithKind(kind+"List"), &unstructured.UnstructuredList{})
+	}
+	return scheme
+}
+
+func newPolicyObject(kind string, namespace string, name string, spec map[string]interface{}) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": policyGroup + "/" + policyVersion,
+		"kind":       kind,
+		"metadata": map[string]interface{}{
+			"name": name,
+		},
+		"spec": spec,
+	}}
+	if namespace != "" {
+		obj.SetNamespace(namespace)
+	}
+	return obj
+}
+
+func TestRunReaperPolicies(t *testing.T) {
+	if _, err := kingpin.CommandLine.Parse([]string{"--reaper-policies"}); err != nil {
+		t.Fatal(err)
+	}
+	w := log.NewSyncWriter(os.Stderr)
+	logger := log.NewLogfmtLogger(w)
+
+	timeNow = func() time.Time {
+		t, _ := time.Parse("01/02/2006 15:04:05", "01/01/2020 15:00:00")
+		return t
+	}
+	defer reaperPolicies.set(nil)
+
+	policyClient = dynamicfake.NewSimpleDynamicClient(policyScheme(),
+		newPolicyObject("ReaperPolicy", "user-user1", "jupyter", map[string]interface{}{
+			"selector":     map[string]interface{}{"matchLabels": map[string]interface{}{"app": "jupyter"}},
+			"lifetime":     "1h",
+			"jobLabel":     "session",
+			"relatedKinds": []interface{}{"service"},
+		}),
+		newPolicyObject("ClusterReaperPolicy", "", "rstudio", map[string]interface{}{
+			"selector":        map[string]interface{}{"matchLabels": map[string]interface{}{"app": "rstudio"}},
+			"lifetime":        "3h",
+			"timestamp":       "creation",
+			"reapEvictedPods": false,
+		}),
+		newPolicyObject("ClusterReaperPolicy", "", "invalid", map[string]interface{}{
+			"lifetime": "foo",
+		}),
+	)
+	defer func() { policyClient = nil }()
+
+	clientset := fake.NewSimpleClientset(&v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "jupyter-1",
+			Namespace: "user-user1",
+			Labels:    map[string]string{"app": "jupyter", "session": "1"},
+		},
+		Status: v1.PodStatus{StartTime: &podStartTime},
+	}, &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "jupyter-2",
+			Namespace: "user-user2",
+			Labels:    map[string]string{"app": "jupyter", "session": "2"},
+		},
+		Status: v1.PodStatus{StartTime: &podStartTime},
+	}, &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              "rstudio-1",
+			Namespace:         "user-user2",
+			Labels:            map[string]string{"app": "rstudio"},
+			CreationTimestamp: podStartTime,
+		},
+		Status: v1.PodStatus{Reason: "Evicted"},
+	}, &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "jupyter-1",
+			Namespace: "user-user1",
+			Labels:    map[string]string{"session": "1"},
+		},
+	}, &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "jupyter-1",
+			Namespace: "user-user1",
+			Labels:    map[string]string{"session": "1"},
+		},
+	})
+
+	run(context.TODO(), clientset, logger)
+
+	pods, err := clientset.CoreV1().Pods(metav1.NamespaceAll).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("Unexpected error getting pods: %v", err)
+	}
+	if len(pods.Items) != 2 {
+		t.Errorf("Unexpected number of pods, got: %d", len(pods.Items))
+	}
+	for _, pod := range pods.Items {
+		if pod.Name == "jupyter-1" {
+			t.Errorf("Expected pod %s to be reaped", pod.Name)
+		}
+	}
+	services, _ := clientset.CoreV1().Services("user-user1").List(context.TODO(), metav1.ListOptions{})
+	if len(services.Items) != 0 {
+		t.Errorf("Unexpected number of services, got: %d", len(services.Items))
+	}
+	configmaps, _ := clientset.CoreV1().ConfigMaps("user-user1").List(context.TODO(), metav1.ListOptions{})
+	if len(configmaps.Items) != 1 {
+		t.Errorf("Expected configmap not in relatedKinds to remain, got: %d", len(configmaps.Items))
+	}
+
+	policy, err := policyClient.Resource(reaperPolicyResource).Namespace("user-user1").Get(context.TODO(), "jupyter", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Unexpected error getting policy: %v", err)
+	}
+	expected := map[string]int64{"matchedPods": 1, "podsReaped": 1, "objectsReaped": 2}
+	for field, value := range expected {
+		if val, _, _ := unstructured.NestedInt64(policy.Object, "status", field); val != value {
+			t.Errorf("Unexpected status %s, got: %d expected: %d", field, val, value)
+		}
+	}
+	if _, ok, _ := unstructured.NestedString(policy.Object, "status", "lastEvaluationTime"); !ok {
+		t.Errorf("Expected status lastEvaluationTime to be set")
+	}
+	clusterPolicy, err := policyClient.Resource(clusterReaperPolicyResource).Get(context.TODO(), "rstudio", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Unexpected error getting policy: %v", err)
+	}
+	if val, _, _ := unstructured.NestedInt64(clusterPolicy.Object, "status", "matchedPods"); val != 1 {
+		t.Errorf("Unexpected cluster policy matchedPods, got: %d", val)
+	}
+}
+
+func TestRunReaperPoliciesListErrors(t *testing.T) {
+	if _, err := kingpin.CommandLine.Parse([]string{"--reaper-policies"}); err != nil {
+		t.Fatal(err)
+	}
+	w := log.NewSyncWriter(os.Stderr)
+	logger := log.NewLogfmtLogger(w)
+
+	labels := ""
+	podsLabels = &labels
+	timeNow = func() time.Time {
+		t, _ := time.Parse("01/02/2006 15:04:05", "01/01/2020 15:00:00")
+		return t
+	}
+	defer reaperPolicies.set(nil)
+
+	namespaced, err := newReaperPolicy(newPolicyObject("ReaperPolicy", "user-user1", "jupyter", map[string]interface{}{
+		"selector": map[string]interface{}{"matchLabels": map[string]interface{}{"app": "jupyter"}},
+		"lifetime": "1h",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cluster, err := newReaperPolicy(newPolicyObject("ClusterReaperPolicy", "", "rstudio", map[string]interface{}{
+		"selector": map[string]interface{}{"matchLabels": map[string]interface{}{"app": "rstudio"}},
+		"lifetime": "1h",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	reaperPolicies.set([]*reaperPolicy{namespaced, cluster})
+
+	client := dynamicfake.NewSimpleDynamicClient(policyScheme())
+	client.PrependReactor("list", "reaperpolicies", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		return true, nil, apierrors.NewForbidden(reaperPolicyResource.GroupResource(), "", errors.New("forbidden"))
+	})
+	client.PrependReactor("list", "clusterreaperpolicies", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		return true, nil, apierrors.NewNotFound(clusterReaperPolicyResource.GroupResource(), "")
+	})
+	policyClient = client
+	defer func() { policyClient = nil }()
+
+	clientset := fake.NewSimpleClientset(&v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "jupyter-1",
+			Namespace: "user-user1",
+			Labels:    map[string]string{"app": "jupyter"},
+		},
+		Status: v1.PodStatus{StartTime: &podStartTime},
+	}, &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "rstudio-1",
+			Namespace: "user-user1",
+			Labels:    map[string]string{"app": "rstudio"},
+		},
+		Status: v1.PodStatus{StartTime: &podStartTime},
+	}, &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "annotated-1",
+			Namespace:   "user-user1",
+			Annotations: map[string]string{"pod.kubernetes.io/lifetime": "1h"},
+		},
+		Status: v1.PodStatus{StartTime: &podStartTime},
+	})
+
+	run(context.TODO(), clientset, logger)
+
+	if policies := reaperPolicies.list(); len(policies) != 1 || policies[0] != namespaced {
+		t.Errorf("Expected forbidden ReaperPolicies to be kept and missing ClusterReaperPolicies to be dropped, got: %v", policies)
+	}
+	if val := runFailures.get("list"); val != 1 {
+		t.Errorf("Unexpected list failures, got: %v", val)
+	}
+	pods, err := clientset.CoreV1().Pods(metav1.NamespaceAll).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("Unexpected error getting pods: %v", err)
+	}
+	if len(pods.Items) != 1 || pods.Items[0].Name != "rstudio-1" {
+		t.Errorf("Expected pods to be reaped using the kept policy and annotations but not the dropped policy, got: %v", pods.Items)
+	}
+}
+
+func TestNewReaperPolicyErrors(t *testing.T) {
+	tests := []map[string]interface{}{
+		{"lifetime": "foo"},
+		{"timestamp": "foo"},
+		{"relatedKinds": []interface{}{"pod"}},
+		{"relatedKinds": []interface{}{"deployment"}},
+		{"selector": map[string]interface{}{"matchExpressions": []interface{}{
+			map[string]interface{}{"key": "app", "operator": "Foo"},
+		}}},
+	}
+	for _, spec := range tests {
+		if _, err := newReaperPolicy(newPolicyObject("ReaperPolicy", "user-user1", "test", spec)); err == nil {
+			t.Errorf("Expected error for spec %v", spec)
+		}
+	}
+}
+
+func TestCRDSchemas(t *testing.T) {
+	data, err := ioutil.ReadFile("install/crds.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, doc := range strings.Split(string(data), "\n---\n") {
+		crd := &unstructured.Unstructured{}
+		if err := yaml.Unmarshal([]byte(doc), &crd.Object); err != nil {
+			t.Fatalf("Unexpected error decoding CRD: %v", err)
+		}
+		versions, found, err := unstructured.NestedSlice(crd.Object, "spec", "versions")
+		if err != nil || !found || len(versions) == 0 {
+			t.Errorf("CRD %s has no versions", crd.GetName())
+			continue
+		}
+		for _, version := range versions {
+			v, ok := version.(map[string]interface{})
+			if !ok {
+				t.Errorf("CRD %s has an invalid version", crd.GetName())
+				continue
+			}
+			if _, found, _ := unstructured.NestedMap(v, "schema", "openAPIV3Schema"); !found {
+				t.Errorf("CRD %s version %v has no schema", crd.GetName(), v["name"])
+			}
+		}
+	}
+}
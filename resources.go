@@ -0,0 +1,161 @@
+// Copyright 2020 Ohio Supercomputer Center
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/dynamic"
+)
+
+var (
+	dynamicClient      dynamic.Interface
+	reapResourcesStore = &reapResourceStore{}
+)
+
+// reapResource is a resource from --reap-resources that was found by discovery
+type reapResource struct {
+	name string
+	gvr  schema.GroupVersionResource
+	kind string
+}
+
+type reapResourceStore struct {
+	sync.RWMutex
+	resources []*reapResource
+}
+
+func (s *reapResourceStore) list() []*reapResource {
+	s.RLock()
+	defer s.RUnlock()
+	return s.resources
+}
+
+func (s *reapResourceStore) set(resources []*reapResource) {
+	s.Lock()
+	defer s.Unlock()
+	s.resources = resources
+}
+
+// parseReapResources parses a comma separated list of group/version/resource,
+// resources of the core group are given as version/resource
+func parseReapResources(value string) ([]schema.GroupVersionResource, error) {
+	resources := []schema.GroupVersionResource{}
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		parts := strings.Split(item, "/")
+		var gvr schema.GroupVersionResource
+		switch len(parts) {
+		case 2:
+			gvr = schema.GroupVersionResource{Version: parts[0], Resource: parts[1]}
+		case 3:
+			gvr = schema.GroupVersionResource{Group: parts[0], Version: parts[1], Resource: parts[2]}
+		default:
+			return nil, fmt.Errorf("invalid reap-resources resource %s, expected group/version/resource", item)
+		}
+		for _, part := range parts[len(parts)-2:] {
+			if part == "" {
+				return nil, fmt.Errorf("invalid reap-resources resource %s, expected group/version/resource", item)
+			}
+		}
+		resources = append(resources, gvr)
+	}
+	return resources, nil
+}
+
+// refreshReapResources uses discovery to find the --reap-resources that exist, are namespaced
+// and can be listed and deleted, resources that are not found are logged and ignored
+func refreshReapResources(client discovery.DiscoveryInterface, logger log.Logger) {
+	gvrs, err := parseReapResources(*reapResources)
+	if err != nil {
+		level.Error(logger).Log("msg", "Error parsing reap resources", "err", err)
+		return
+	}
+	resources := []*reapResource{}
+	lists := make(map[string]*metav1.APIResourceList)
+	for _, gvr := range gvrs {
+		resourceLogger := log.With(logger, "resource", resourceName(gvr))
+		groupVersion := gvr.GroupVersion().String()
+		list, ok := lists[groupVersion]
+		if !ok {
+			list, err = client.ServerResourcesForGroupVersion(groupVersion)
+			if err != nil {
+				level.Error(resourceLogger).Log("msg", errorMessage("Error discovering resource", err), "err", err)
+			}
+			lists[groupVersion] = list
+		}
+		resource := findAPIResource(list, gvr.Resource)
+		switch {
+		case resource == nil:
+			level.Warn(resourceLogger).Log("msg", "Resource not found, ignoring")
+		case !resource.Namespaced:
+			level.Warn(resourceLogger).Log("msg", "Resource is not namespaced, ignoring")
+		case !sliceContains(resource.Verbs, "list") || !sliceContains(resource.Verbs, "delete"):
+			level.Warn(resourceLogger).Log("msg", "Resource does not support list and delete, ignoring")
+		default:
+			resources = append(resources, &reapResource{name: resourceName(gvr), gvr: gvr, kind: resource.Kind})
+		}
+	}
+	reapResourcesStore.set(resources)
+}
+
+func findAPIResource(list *metav1.APIResourceList, resource string) *metav1.APIResource {
+	if list == nil {
+		return nil
+	}
+	for i := range list.APIResources {
+		if list.APIResources[i].Name == resource {
+			return &list.APIResources[i]
+		}
+	}
+	return nil
+}
+
+// resourceName returns the group/version/resource of gvr
+func resourceName(gvr schema.GroupVersionResource) string {
+	return gvr.GroupVersion().String() + "/" + gvr.Resource
+}
+
+// reapResourcesFor returns the --reap-resources reaped with the pods of the policy,
+// policies with related kinds only reap those kinds
+func reapResourcesFor(policy *reaperPolicy) []*reapResource {
+	if policy != nil && policy.relatedKinds != nil {
+		return nil
+	}
+	return reapResourcesStore.list()
+}
+
+// listResourceObjects lists a page of objects of the resource and returns the objects and the continue token
+func listResourceObjects(ctx context.Context, resource *reapResource, namespace string, options metav1.ListOptions) ([]metav1.Object, string, error) {
+	list, err := dynamicClient.Resource(resource.gvr).Namespace(namespace).List(ctx, options)
+	if err != nil {
+		return nil, "", err
+	}
+	objects := []metav1.Object{}
+	for i := range list.Items {
+		objects = append(objects, &list.Items[i])
+	}
+	return objects, list.GetContinue(), nil
+}
@@ -0,0 +1,117 @@
+// Copyright 2020 Ohio Supercomputer Center
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestParseReapResources(t *testing.T) {
+	resources, err := parseReapResources("osc.edu/v1/sessionroutes, v1/persistentvolumeclaims")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := []schema.GroupVersionResource{
+		{Group: "osc.edu", Version: "v1", Resource: "sessionroutes"},
+		{Version: "v1", Resource: "persistentvolumeclaims"},
+	}
+	if !reflect.DeepEqual(resources, expected) {
+		t.Errorf("Unexpected resources\nGot: %v\nExpected: %v", resources, expected)
+	}
+	for _, value := range []string{"sessionroutes", "osc.edu/v1/", "osc.edu/v1/sessionroutes/foo"} {
+		if _, err := parseReapResources(value); err == nil {
+			t.Errorf("Expected error for %s", value)
+		}
+	}
+}
+
+func sessionRoute(name string, job string) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "osc.edu/v1",
+		"kind":       "SessionRoute",
+		"metadata": map[string]interface{}{
+			"name":      name,
+			"namespace": "user-user1",
+			"labels":    map[string]interface{}{"job": job},
+		},
+	}}
+}
+
+func TestReapResources(t *testing.T) {
+	if _, err := kingpin.CommandLine.Parse([]string{"--reap-resources=osc.edu/v1/sessionroutes,osc.edu/v1/clusterroutes,osc.edu/v1/missing"}); err != nil {
+		t.Fatal(err)
+	}
+	w := log.NewSyncWriter(os.Stderr)
+	logger := log.NewLogfmtLogger(w)
+	defer reapResourcesStore.set(nil)
+
+	scheme := runtime.NewScheme()
+	gv := schema.GroupVersion{Group: "osc.edu", Version: "v1"}
+	scheme.AddKnownTypeWithName(gv.WithKind("SessionRoute"), &unstructured.Unstructured{})
+	scheme.AddKnownTypeWithName(gv.WithKind("SessionRouteList"), &unstructured.UnstructuredList{})
+	dynamicClient = dynamicfake.NewSimpleDynamicClient(scheme, sessionRoute("route-job1", "1"), sessionRoute("route-job2", "2"))
+	defer func() { dynamicClient = nil }()
+
+	clientset := fake.NewSimpleClientset(&v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "ondemand-job1",
+			Namespace: "user-user1",
+			Labels:    map[string]string{"job": "1"},
+		},
+	})
+	clientset.Resources = []*metav1.APIResourceList{{
+		GroupVersion: "osc.edu/v1",
+		APIResources: []metav1.APIResource{
+			{Name: "sessionroutes", Kind: "SessionRoute", Namespaced: true, Verbs: metav1.Verbs{"list", "delete"}},
+			{Name: "clusterroutes", Kind: "ClusterRoute", Namespaced: false, Verbs: metav1.Verbs{"list", "delete"}},
+		},
+	}}
+	refreshReapResources(clientset.Discovery(), logger)
+	if resources := reapResourcesStore.list(); len(resources) != 1 || resources[0].kind != "SessionRoute" {
+		t.Fatalf("Unexpected resources discovered, got: %v", resources)
+	}
+
+	jobs := []podJob{{jobID: "1", podName: "ondemand-job1", namespace: "user-user1", reason: reasonLifetime}}
+	jobObjects, err := getJobObjects(context.TODO(), clientset, jobs, logger)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(jobObjects) != 2 || jobObjects[1].objectType != "osc.edu/v1/sessionroutes" || jobObjects[1].name != "route-job1" {
+		t.Errorf("Unexpected job objects, got: %v", jobObjects)
+	}
+	if err := reap(context.TODO(), clientset, jobObjects, logger); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	gvr := schema.GroupVersionResource{Group: "osc.edu", Version: "v1", Resource: "sessionroutes"}
+	routes, err := dynamicClient.Resource(gvr).Namespace("user-user1").List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(routes.Items) != 1 || routes.Items[0].GetName() != "route-job2" {
+		t.Errorf("Expected only the route of the reaped job to be deleted, got: %v", routes.Items)
+	}
+}
@@ -0,0 +1,106 @@
+// Copyright 2020 Ohio Supercomputer Center
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+var (
+	runFailures = &apiFailures{}
+)
+
+// apiFailures counts API calls that failed after retrying since the last reset
+type apiFailures struct {
+	sync.Mutex
+	counts map[string]int
+}
+
+func (f *apiFailures) reset() {
+	f.Lock()
+	defer f.Unlock()
+	f.counts = make(map[string]int)
+}
+
+// record counts a failed operation, forbidden errors are also counted separately
+func (f *apiFailures) record(operation string, err error) {
+	f.Lock()
+	defer f.Unlock()
+	if f.counts == nil {
+		f.counts = make(map[string]int)
+	}
+	f.counts[operation]++
+	if apierrors.IsForbidden(err) {
+		f.counts["forbidden"]++
+	}
+}
+
+func (f *apiFailures) get(key string) int {
+	f.Lock()
+	defer f.Unlock()
+	return f.counts[key]
+}
+
+// retryable returns whether err is a transient API error that should be retried
+func retryable(err error) bool {
+	if apierrors.IsConflict(err) || apierrors.IsTooManyRequests(err) || apierrors.IsServerTimeout(err) || apierrors.IsTimeout(err) {
+		return true
+	}
+	var status apierrors.APIStatus
+	if errors.As(err, &status) {
+		return status.Status().Code >= http.StatusInternalServerError
+	}
+	return false
+}
+
+// withRetry calls fn until it succeeds, returns an error that is not retryable
+// or --api-retries is reached, waiting an exponential backoff with jitter between attempts
+func withRetry(ctx context.Context, logger log.Logger, fn func() error) error {
+	backoff := wait.Backoff{
+		Duration: *apiRetryBackoff,
+		Factor:   2,
+		Jitter:   0.5,
+		Steps:    *apiRetries + 1,
+	}
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || !retryable(err) || attempt > *apiRetries {
+			return err
+		}
+		delay := backoff.Step()
+		level.Debug(logger).Log("msg", "Retrying after transient API error", "attempt", attempt, "delay", delay, "err", err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+	}
+}
+
+// errorMessage returns a log message for a failed operation that distinguishes missing RBAC permissions
+func errorMessage(msg string, err error) string {
+	if apierrors.IsForbidden(err) {
+		return msg + ", forbidden by RBAC"
+	}
+	return msg
+}
@@ -0,0 +1,160 @@
+// Copyright 2020 Ohio Supercomputer Center
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+func TestRetryable(t *testing.T) {
+	pods := schema.GroupResource{Resource: "pods"}
+	tests := []struct {
+		err       error
+		retryable bool
+	}{
+		{apierrors.NewConflict(pods, "pod1", fmt.Errorf("conflict")), true},
+		{apierrors.NewTooManyRequests("slow down", 1), true},
+		{apierrors.NewServerTimeout(pods, "list", 1), true},
+		{apierrors.NewInternalError(fmt.Errorf("internal")), true},
+		{apierrors.NewServiceUnavailable("unavailable"), true},
+		{apierrors.NewNotFound(pods, "pod1"), false},
+		{apierrors.NewForbidden(pods, "pod1", fmt.Errorf("forbidden")), false},
+		{apierrors.NewBadRequest("bad"), false},
+		{fmt.Errorf("other"), false},
+	}
+	for _, test := range tests {
+		if retryable(test.err) != test.retryable {
+			t.Errorf("Unexpected retryable for %v, expected: %t", test.err, test.retryable)
+		}
+	}
+}
+
+func TestWithRetry(t *testing.T) {
+	if _, err := kingpin.CommandLine.Parse([]string{"--api-retries=2", "--api-retry-backoff=1ms"}); err != nil {
+		t.Fatal(err)
+	}
+	attempts := 0
+	err := withRetry(context.TODO(), log.NewNopLogger(), func() error {
+		attempts++
+		return apierrors.NewTooManyRequests("slow down", 1)
+	})
+	if !apierrors.IsTooManyRequests(err) || attempts != 3 {
+		t.Errorf("Unexpected result, got: %v after %d attempts", err, attempts)
+	}
+	attempts = 0
+	err = withRetry(context.TODO(), log.NewNopLogger(), func() error {
+		attempts++
+		return apierrors.NewForbidden(schema.GroupResource{Resource: "pods"}, "pod1", fmt.Errorf("forbidden"))
+	})
+	if !apierrors.IsForbidden(err) || attempts != 1 {
+		t.Errorf("Unexpected result, got: %v after %d attempts", err, attempts)
+	}
+}
+
+func TestReapRetries(t *testing.T) {
+	if _, err := kingpin.CommandLine.Parse([]string{"--api-retries=2", "--api-retry-backoff=1ms"}); err != nil {
+		t.Fatal(err)
+	}
+	w := log.NewSyncWriter(os.Stderr)
+	logger := log.NewLogfmtLogger(w)
+	runFailures.reset()
+
+	clientset := fake.NewSimpleClientset(&v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "ondemand-job1",
+			Namespace: "user-user1",
+		},
+	}, &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "ondemand-job3",
+			Namespace: "user-user3",
+		},
+	})
+	throttled := false
+	clientset.PrependReactor("delete", "*", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		deleteAction := action.(clienttesting.DeleteAction)
+		switch {
+		case deleteAction.GetName() == "ondemand-job1" && !throttled:
+			throttled = true
+			return true, nil, apierrors.NewTooManyRequests("slow down", 1)
+		case deleteAction.GetNamespace() == "user-user3":
+			return true, nil, apierrors.NewForbidden(action.GetResource().GroupResource(), deleteAction.GetName(), fmt.Errorf("forbidden"))
+		}
+		return false, nil, nil
+	})
+	jobObjects := []jobObject{
+		{objectType: "pod", jobID: "1", name: "ondemand-job1", namespace: "user-user1", reason: reasonLifetime},
+		{objectType: "pod", jobID: "2", name: "ondemand-job2", namespace: "user-user2", reason: reasonLifetime},
+		{objectType: "pod", jobID: "3", name: "ondemand-job3", namespace: "user-user3", reason: reasonLifetime},
+	}
+	if err := reap(context.TODO(), clientset, jobObjects, logger); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if _, err := clientset.CoreV1().Pods("user-user1").Get(context.TODO(), "ondemand-job1", metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+		t.Errorf("Expected throttled pod to be deleted after retrying, got: %v", err)
+	}
+	if val := runFailures.get("delete"); val != 1 {
+		t.Errorf("Unexpected delete failures, got: %d", val)
+	}
+	if val := runFailures.get("forbidden"); val != 1 {
+		t.Errorf("Unexpected forbidden failures, got: %d", val)
+	}
+}
+
+func TestGetJobObjectsSkipsFailedJobs(t *testing.T) {
+	if _, err := kingpin.CommandLine.Parse([]string{"--api-retries=1", "--api-retry-backoff=1ms"}); err != nil {
+		t.Fatal(err)
+	}
+	runFailures.reset()
+	clientset := fake.NewSimpleClientset(&v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "service-job1",
+			Namespace: "user-user1",
+			Labels:    map[string]string{"job": "1"},
+		},
+	})
+	clientset.PrependReactor("list", "services", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		if action.GetNamespace() == "user-user2" {
+			return true, nil, apierrors.NewInternalError(fmt.Errorf("internal"))
+		}
+		return false, nil, nil
+	})
+	jobs := []podJob{
+		{jobID: "1", podName: "ondemand-job1", namespace: "user-user1", reason: reasonLifetime},
+		{jobID: "2", podName: "ondemand-job2", namespace: "user-user2", reason: reasonLifetime},
+	}
+	jobObjects, err := getJobObjects(context.TODO(), clientset, jobs, log.NewNopLogger())
+	if !apierrors.IsInternalError(err) {
+		t.Errorf("Expected error for skipped job, got: %v", err)
+	}
+	if len(jobObjects) != 2 || jobObjects[0].name != "ondemand-job1" || jobObjects[1].name != "service-job1" {
+		t.Errorf("Unexpected job objects, got: %v", jobObjects)
+	}
+	if val := runFailures.get("list"); val != 1 {
+		t.Errorf("Unexpected list failures, got: %d", val)
+	}
+}
@@ -0,0 +1,178 @@
+// Copyright 2020 Ohio Supercomputer Center
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+var (
+	orphans = &orphanTracker{firstSeen: make(map[string]time.Time)}
+)
+
+// orphanTracker holds when each object with the job label was first seen without a pod of its job,
+// it is kept in memory so the grace period starts again when the job-pod-reaper restarts
+type orphanTracker struct {
+	sync.Mutex
+	firstSeen map[string]time.Time
+}
+
+// observe records the orphaned objects seen during a sweep, objects no longer
+// orphaned are forgotten, and returns how long each object has been orphaned
+func (o *orphanTracker) observe(keys []string, now time.Time) map[string]time.Duration {
+	o.Lock()
+	defer o.Unlock()
+	firstSeen := make(map[string]time.Time)
+	orphaned := make(map[string]time.Duration)
+	for _, key := range keys {
+		seen, ok := o.firstSeen[key]
+		if !ok {
+			seen = now
+		}
+		firstSeen[key] = seen
+		orphaned[key] = now.Sub(seen)
+	}
+	o.firstSeen = firstSeen
+	return orphaned
+}
+
+// sweepOrphans reaps objects with --job-label whose job has had no pod for longer than
+// --orphan-grace-period, the objects reaped by the run count toward --reap-max and --reap-max-objects
+func sweepOrphans(ctx context.Context, clientset kubernetes.Interface, namespaces []string, reaped []jobObject, logger log.Logger) error {
+	if *orphanGracePeriod == 0 {
+		return nil
+	}
+	sweepLogger := log.With(logger, "sweep", "orphans")
+	maxJobs, maxObjects := *reapMax, *reapMaxObjects
+	if maxJobs != 0 {
+		maxJobs -= len(groupJobObjects(reaped))
+	}
+	if maxObjects != 0 {
+		maxObjects -= len(reaped)
+	}
+	if (*reapMax != 0 && maxJobs <= 0) || (*reapMaxObjects != 0 && maxObjects <= 0) {
+		level.Debug(sweepLogger).Log("msg", "Max reap reached, skipping sweep", "max", *reapMax, "max_objects", *reapMaxObjects)
+		return nil
+	}
+	listOptions := metav1.ListOptions{LabelSelector: *jobLabel}
+	jobs := make(map[string]bool)
+	candidates := []jobObject{}
+	for _, ns := range namespaces {
+		err := listPages(ctx, sweepLogger, listOptions, func(options metav1.ListOptions) (string, bool, error) {
+			pods, err := clientset.CoreV1().Pods(ns).List(ctx, options)
+			if err != nil {
+				return "", false, err
+			}
+			for _, pod := range pods.Items {
+				jobs[pod.Namespace+"/"+pod.Labels[*jobLabel]] = true
+			}
+			return pods.Continue, true, nil
+		})
+		if err != nil {
+			runFailures.record("list", err)
+			level.Error(sweepLogger).Log("msg", errorMessage("Error getting pod list", err), "namespace", ns, "err", err)
+			return err
+		}
+		add := func(objectType string, resource *reapResource, objects []metav1.Object) {
+			for _, object := range objects {
+				candidates = append(candidates, jobObject{objectType: objectType, jobID: object.GetLabels()[*jobLabel],
+					name: object.GetName(), namespace: object.GetNamespace(), reason: reasonOrphaned, resource: resource})
+			}
+		}
+		for _, objectType := range defaultRelatedKinds() {
+			err := listPages(ctx, sweepLogger, listOptions, func(options metav1.ListOptions) (string, bool, error) {
+				objects, next, err := listObjects(ctx, clientset, objectType, ns, options)
+				if err != nil {
+					return "", false, err
+				}
+				add(objectType, nil, objects)
+				return next, true, nil
+			})
+			if err != nil {
+				runFailures.record("list", err)
+				level.Error(sweepLogger).Log("msg", errorMessage("Error getting "+objectKinds[objectType].resource, err), "namespace", ns, "err", err)
+				return err
+			}
+		}
+		for _, resource := range reapResourcesStore.list() {
+			err := listPages(ctx, sweepLogger, listOptions, func(options metav1.ListOptions) (string, bool, error) {
+				objects, next, err := listResourceObjects(ctx, resource, ns, options)
+				if err != nil {
+					return "", false, err
+				}
+				add(resource.name, resource, objects)
+				return next, true, nil
+			})
+			if err != nil {
+				runFailures.record("list", err)
+				level.Error(sweepLogger).Log("msg", errorMessage("Error getting "+resource.name, err), "namespace", ns, "err", err)
+				return err
+			}
+		}
+	}
+	objectKeys := []string{}
+	orphanedObjects := []jobObject{}
+	for _, object := range candidates {
+		if jobs[object.namespace+"/"+object.jobID] {
+			continue
+		}
+		objectKeys = append(objectKeys, object.namespace+"/"+object.objectType+"/"+object.name)
+		orphanedObjects = append(orphanedObjects, object)
+	}
+	orphaned := orphans.observe(objectKeys, timeNow())
+	jobObjects := []jobObject{}
+	for i, object := range orphanedObjects {
+		object.age = orphaned[objectKeys[i]]
+		object.lifetime = *orphanGracePeriod
+		if object.age < *orphanGracePeriod {
+			level.Debug(sweepLogger).Log("msg", "Object has no pod, waiting for grace period", "job", object.jobID,
+				"type", object.objectType, "name", object.name, "namespace", object.namespace, "orphaned", object.age)
+			continue
+		}
+		jobObjects = append(jobObjects, object)
+	}
+	if len(jobObjects) == 0 {
+		return nil
+	}
+	// Objects of the same job are reaped together
+	sort.SliceStable(jobObjects, func(i, j int) bool {
+		if jobObjects[i].namespace != jobObjects[j].namespace {
+			return jobObjects[i].namespace < jobObjects[j].namespace
+		}
+		return jobObjects[i].jobID < jobObjects[j].jobID
+	})
+	if groups := groupJobObjects(jobObjects); maxJobs != 0 && len(groups) > maxJobs {
+		level.Info(sweepLogger).Log("msg", "Max reap reached, skipping rest", "max", *reapMax, "skipped", len(groups)-maxJobs)
+		jobObjects = []jobObject{}
+		for _, objects := range groups[:maxJobs] {
+			jobObjects = append(jobObjects, objects...)
+		}
+	}
+	jobObjects = limitJobObjects(jobObjects, maxObjects, sweepLogger)
+	level.Info(sweepLogger).Log("msg", "Reaping orphaned objects", "count", len(jobObjects))
+	deleted := 0
+	for _, count := range reapObjects(ctx, clientset, jobObjects, sweepLogger) {
+		deleted += count
+	}
+	level.Info(sweepLogger).Log("msg", "Reaped orphaned objects", "dry_run", *dryRun, "objects", deleted)
+	return nil
+}
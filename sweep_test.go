@@ -0,0 +1,172 @@
+// Copyright 2020 Ohio Supercomputer Center
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestSweepOrphans(t *testing.T) {
+	if _, err := kingpin.CommandLine.Parse([]string{"--orphan-grace-period=1h"}); err != nil {
+		t.Fatal(err)
+	}
+	w := log.NewSyncWriter(os.Stderr)
+	logger := log.NewLogfmtLogger(w)
+	reapResourcesStore.set(nil)
+	orphans = &orphanTracker{firstSeen: make(map[string]time.Time)}
+	now := time.Now()
+	timeNow = func() time.Time {
+		return now
+	}
+
+	clientset := fake.NewSimpleClientset(&v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "ondemand-job1",
+			Namespace: "user-user1",
+			Labels:    map[string]string{"job": "1"},
+		},
+	}, &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "service-job1",
+			Namespace: "user-user1",
+			Labels:    map[string]string{"job": "1"},
+		},
+	}, &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "service-job2",
+			Namespace: "user-user1",
+			Labels:    map[string]string{"job": "2"},
+		},
+	}, &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "configmap-job2",
+			Namespace: "user-user1",
+			Labels:    map[string]string{"job": "2"},
+		},
+	}, &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "secret-job3",
+			Namespace: "user-user2",
+			Labels:    map[string]string{"job": "3"},
+		},
+	}, &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "secret-other",
+			Namespace: "user-user2",
+		},
+	})
+	namespaces := []string{"user-user1", "user-user2"}
+	if err := sweepOrphans(context.TODO(), clientset, namespaces, nil, logger); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	services, _ := clientset.CoreV1().Services("user-user1").List(context.TODO(), metav1.ListOptions{})
+	if len(services.Items) != 2 {
+		t.Errorf("Expected orphans to be kept during grace period, got: %d services", len(services.Items))
+	}
+
+	now = now.Add(2 * time.Hour)
+	if err := sweepOrphans(context.TODO(), clientset, namespaces, nil, logger); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if _, err := clientset.CoreV1().Services("user-user1").Get(context.TODO(), "service-job1", metav1.GetOptions{}); err != nil {
+		t.Errorf("Expected service with a pod to be kept, got: %v", err)
+	}
+	if _, err := clientset.CoreV1().Services("user-user1").Get(context.TODO(), "service-job2", metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+		t.Errorf("Expected orphaned service to be deleted, got: %v", err)
+	}
+	if _, err := clientset.CoreV1().ConfigMaps("user-user1").Get(context.TODO(), "configmap-job2", metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+		t.Errorf("Expected orphaned configmap to be deleted, got: %v", err)
+	}
+	if _, err := clientset.CoreV1().Secrets("user-user2").Get(context.TODO(), "secret-job3", metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+		t.Errorf("Expected orphaned secret to be deleted, got: %v", err)
+	}
+	if _, err := clientset.CoreV1().Secrets("user-user2").Get(context.TODO(), "secret-other", metav1.GetOptions{}); err != nil {
+		t.Errorf("Expected secret without job label to be kept, got: %v", err)
+	}
+}
+
+func TestSweepOrphansReapMax(t *testing.T) {
+	if _, err := kingpin.CommandLine.Parse([]string{"--orphan-grace-period=1h", "--reap-max=2", "--reap-max-objects=3"}); err != nil {
+		t.Fatal(err)
+	}
+	w := log.NewSyncWriter(os.Stderr)
+	logger := log.NewLogfmtLogger(w)
+	reapResourcesStore.set(nil)
+	orphans = &orphanTracker{firstSeen: make(map[string]time.Time)}
+	now := time.Now()
+	timeNow = func() time.Time {
+		return now
+	}
+
+	clientset := fake.NewSimpleClientset(&v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "service-job2",
+			Namespace: "user-user1",
+			Labels:    map[string]string{"job": "2"},
+		},
+	}, &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "configmap-job2",
+			Namespace: "user-user1",
+			Labels:    map[string]string{"job": "2"},
+		},
+	}, &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "secret-job3",
+			Namespace: "user-user2",
+			Labels:    map[string]string{"job": "3"},
+		},
+	})
+	namespaces := []string{"user-user1", "user-user2"}
+	if err := sweepOrphans(context.TODO(), clientset, namespaces, nil, logger); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	now = now.Add(2 * time.Hour)
+
+	// The run reaped as many jobs as --reap-max so nothing is swept
+	reaped := []jobObject{
+		{objectType: "pod", jobID: "1", name: "ondemand-job1", namespace: "user-user1"},
+		{objectType: "pod", jobID: "4", name: "ondemand-job4", namespace: "user-user1"},
+	}
+	if err := sweepOrphans(context.TODO(), clientset, namespaces, reaped, logger); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if _, err := clientset.CoreV1().Services("user-user1").Get(context.TODO(), "service-job2", metav1.GetOptions{}); err != nil {
+		t.Errorf("Expected orphaned service to be kept once reap-max reached, got: %v", err)
+	}
+
+	// One job and two objects remain of the limits
+	if err := sweepOrphans(context.TODO(), clientset, namespaces, reaped[:1], logger); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if _, err := clientset.CoreV1().Services("user-user1").Get(context.TODO(), "service-job2", metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+		t.Errorf("Expected orphaned service to be deleted, got: %v", err)
+	}
+	if _, err := clientset.CoreV1().ConfigMaps("user-user1").Get(context.TODO(), "configmap-job2", metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+		t.Errorf("Expected orphaned configmap to be deleted, got: %v", err)
+	}
+	if _, err := clientset.CoreV1().Secrets("user-user2").Get(context.TODO(), "secret-job3", metav1.GetOptions{}); err != nil {
+		t.Errorf("Expected orphaned secret beyond reap-max to be kept, got: %v", err)
+	}
+}
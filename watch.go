@@ -0,0 +1,222 @@
+// Copyright 2020 Ohio Supercomputer Center
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// podWatcher reaps pods at their expiry time using shared informers
+// rather than listing every pod each --reap-interval
+type podWatcher struct {
+	// lock prevents configuration reloads while a pod is being reaped
+	lock      sync.Mutex
+	clientset kubernetes.Interface
+	logger    log.Logger
+	queue     workqueue.RateLimitingInterface
+	factories []informers.SharedInformerFactory
+	informers []cache.SharedIndexInformer
+}
+
+func newPodWatcher(clientset kubernetes.Interface, namespaces []string, logger log.Logger) *podWatcher {
+	w := &podWatcher{
+		clientset: clientset,
+		logger:    logger,
+		queue:     workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "pods"),
+	}
+	labels := strings.Split(*podsLabels, ",")
+	for _, ns := range namespaces {
+		for _, l := range labels {
+			selector := l
+			factory := informers.NewSharedInformerFactoryWithOptions(clientset, *reapInterval,
+				informers.WithNamespace(ns),
+				informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+					options.LabelSelector = selector
+				}))
+			informer := factory.Core().V1().Pods().Informer()
+			informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+				AddFunc: w.enqueue,
+				UpdateFunc: func(oldObj, newObj interface{}) {
+					w.enqueue(newObj)
+				},
+			})
+			w.factories = append(w.factories, factory)
+			w.informers = append(w.informers, informer)
+		}
+	}
+	return w
+}
+
+func watchLoop(ctx context.Context, clientset kubernetes.Interface, logger log.Logger) {
+	namespaces, err := getNamespaces(ctx, clientset, logger)
+	if err != nil {
+		level.Error(logger).Log("msg", "Error getting namespaces", "err", err)
+		return
+	}
+	w := newPodWatcher(clientset, namespaces, logger)
+	w.run(ctx)
+}
+
+func (w *podWatcher) run(ctx context.Context) {
+	var wg sync.WaitGroup
+	// The worker is waited for so a pod being reaped has all of its objects deleted before returning
+	defer wg.Wait()
+	defer w.queue.ShutDown()
+	for _, factory := range w.factories {
+		factory.Start(ctx.Done())
+	}
+	hasSynced := []cache.InformerSynced{}
+	for _, informer := range w.informers {
+		hasSynced = append(hasSynced, informer.HasSynced)
+	}
+	level.Info(w.logger).Log("msg", "Waiting for pod informer caches to sync")
+	if !cache.WaitForCacheSync(ctx.Done(), hasSynced...) {
+		level.Error(w.logger).Log("msg", "Timed out waiting for pod informer caches to sync")
+		return
+	}
+	level.Info(w.logger).Log("msg", "Pod informer caches synced, watching pods")
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		wait.Until(func() { w.runWorker(ctx) }, time.Second, ctx.Done())
+	}()
+	wait.Until(func() { w.resync(ctx) }, *reapInterval, ctx.Done())
+	level.Info(w.logger).Log("msg", "Shutting down pod watcher")
+}
+
+func (w *podWatcher) enqueue(obj interface{}) {
+	key, err := cache.MetaNamespaceKeyFunc(obj)
+	if err != nil {
+		level.Error(w.logger).Log("msg", "Error getting pod key", "err", err)
+		return
+	}
+	w.queue.Add(key)
+}
+
+func (w *podWatcher) getPod(key string) (*v1.Pod, bool) {
+	for _, informer := range w.informers {
+		obj, exists, err := informer.GetIndexer().GetByKey(key)
+		if err != nil || !exists {
+			continue
+		}
+		if pod, ok := obj.(*v1.Pod); ok {
+			return pod, true
+		}
+	}
+	return nil, false
+}
+
+func (w *podWatcher) runWorker(ctx context.Context) {
+	for w.processNextItem(ctx) {
+	}
+}
+
+func (w *podWatcher) processNextItem(ctx context.Context) bool {
+	key, quit := w.queue.Get()
+	if quit {
+		return false
+	}
+	defer w.queue.Done(key)
+	if err := w.reapPod(ctx, key.(string)); err != nil {
+		w.queue.AddRateLimited(key)
+		return true
+	}
+	w.queue.Forget(key)
+	return true
+}
+
+// reapPod reaps the pod if it has expired, otherwise schedules the pod
+// to be processed again once it reaches its expiry time
+func (w *podWatcher) reapPod(ctx context.Context, key string) error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	runFailures.reset()
+	pod, ok := w.getPod(key)
+	if !ok || pod.DeletionTimestamp != nil {
+		return nil
+	}
+	podLogger := log.With(w.logger, "pod", pod.Name, "namespace", pod.Namespace)
+	job, ok := evaluatePod(ctx, w.clientset, pod, podLogger)
+	if !ok {
+		if expiry, _, ok := podExpiry(pod, podLogger); ok && !expiry.IsZero() {
+			delay := expiry.Sub(timeNow()) + time.Second
+			if *warningWindow > 0 && delay > *warningWindow {
+				delay -= *warningWindow
+				level.Debug(podLogger).Log("msg", "Scheduling pod to be warned", "expiry", expiry, "delay", delay)
+			} else {
+				level.Debug(podLogger).Log("msg", "Scheduling pod to be reaped", "expiry", expiry, "delay", delay)
+			}
+			w.queue.AddAfter(key, delay)
+		}
+		return nil
+	}
+	jobObjects, err := getJobObjects(ctx, w.clientset, []podJob{job}, w.logger)
+	if err != nil {
+		level.Error(podLogger).Log("msg", "Error getting job objects", "err", err)
+		return err
+	}
+	return reap(ctx, w.clientset, jobObjects, w.logger)
+}
+
+// resync reloads the configuration file, updates the status of policies
+// and refreshes policies, namespace lifetime policies and the tracked pods gauge
+func (w *podWatcher) resync(ctx context.Context) {
+	w.lock.Lock()
+	reloadConfig(w.logger)
+	refreshReapResources(w.clientset.Discovery(), w.logger)
+	updateReaperPolicyStatus(ctx, w.logger)
+	loadReaperPolicies(ctx, w.logger)
+	w.lock.Unlock()
+	namespaces, err := getNamespaces(ctx, w.clientset, w.logger)
+	if err != nil {
+		level.Error(w.logger).Log("msg", "Error refreshing namespaces", "err", err)
+		return
+	}
+	w.lock.Lock()
+	err = sweepOrphans(ctx, w.clientset, namespaces, nil, w.logger)
+	w.lock.Unlock()
+	if err != nil {
+		level.Error(w.logger).Log("msg", "Error sweeping orphaned objects", "err", err)
+	}
+	tracked := 0
+	seen := make(map[string]bool)
+	for _, informer := range w.informers {
+		for _, obj := range informer.GetStore().List() {
+			pod, ok := obj.(*v1.Pod)
+			if !ok {
+				continue
+			}
+			key := pod.Namespace + "/" + pod.Name
+			if podTracked(pod) && !seen[key] {
+				seen[key] = true
+				tracked++
+			}
+		}
+	}
+	metricTrackedPods.Set(float64(tracked))
+	metricLastSuccess.SetToCurrentTime()
+}
@@ -0,0 +1,210 @@
+// Copyright 2020 Ohio Supercomputer Center
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestPodWatcherReapPod(t *testing.T) {
+	if _, err := kingpin.CommandLine.Parse([]string{"--watch"}); err != nil {
+		t.Fatal(err)
+	}
+	w := log.NewSyncWriter(os.Stderr)
+	logger := log.NewLogfmtLogger(w)
+
+	labels := "app.kubernetes.io/managed-by=open-ondemand"
+	podsLabels = &labels
+
+	timeNow = func() time.Time {
+		t, _ := time.Parse("01/02/2006 15:04:05", "01/01/2020 13:45:00")
+		return t
+	}
+
+	clientset := fake.NewSimpleClientset(&v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "ondemand-job1",
+			Namespace: "user-user1",
+			Annotations: map[string]string{
+				"pod.kubernetes.io/lifetime": "1h",
+			},
+			Labels: map[string]string{
+				"job":                          "1",
+				"app.kubernetes.io/managed-by": "open-ondemand",
+			},
+		},
+		Status: v1.PodStatus{
+			StartTime: &podStartTime,
+		},
+	}, &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "ondemand-job2",
+			Namespace: "user-user2",
+			Annotations: map[string]string{
+				"pod.kubernetes.io/lifetime": "30m",
+			},
+			Labels: map[string]string{
+				"job":                          "2",
+				"app.kubernetes.io/managed-by": "open-ondemand",
+			},
+		},
+		Status: v1.PodStatus{
+			StartTime: &podStartTime,
+		},
+	}, &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "service-job2",
+			Namespace: "user-user2",
+			Labels: map[string]string{
+				"job": "2",
+			},
+		},
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	watcher := newPodWatcher(clientset, []string{metav1.NamespaceAll}, logger)
+	defer watcher.queue.ShutDown()
+	for _, factory := range watcher.factories {
+		factory.Start(ctx.Done())
+	}
+	for _, informer := range watcher.informers {
+		if !cache.WaitForCacheSync(ctx.Done(), informer.HasSynced) {
+			t.Fatal("Timed out waiting for caches to sync")
+		}
+	}
+
+	if err := watcher.reapPod(ctx, "user-user1/ondemand-job1"); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if err := watcher.reapPod(ctx, "user-user2/ondemand-job2"); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if err := watcher.reapPod(ctx, "user-user3/does-not-exist"); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	pods, err := clientset.CoreV1().Pods(metav1.NamespaceAll).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		t.Errorf("Unexpected error getting pods: %v", err)
+	}
+	if len(pods.Items) != 1 {
+		t.Errorf("Unexpected number of pods, got: %d", len(pods.Items))
+		return
+	}
+	if pods.Items[0].Name != "ondemand-job1" {
+		t.Errorf("Unexpected pod remaining, got: %s", pods.Items[0].Name)
+	}
+	services, err := clientset.CoreV1().Services(metav1.NamespaceAll).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		t.Errorf("Unexpected error getting services: %v", err)
+	}
+	if len(services.Items) != 0 {
+		t.Errorf("Unexpected number of services, got: %d", len(services.Items))
+	}
+}
+
+func TestPodWatcherReapTerminatingPod(t *testing.T) {
+	if _, err := kingpin.CommandLine.Parse([]string{"--watch"}); err != nil {
+		t.Fatal(err)
+	}
+	w := log.NewSyncWriter(os.Stderr)
+	logger := log.NewLogfmtLogger(w)
+
+	labels := "app.kubernetes.io/managed-by=open-ondemand"
+	podsLabels = &labels
+
+	timeNow = func() time.Time {
+		t, _ := time.Parse("01/02/2006 15:04:05", "01/01/2020 13:45:00")
+		return t
+	}
+
+	clientset := fake.NewSimpleClientset(&v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "ondemand-job1",
+			Namespace: "user-user1",
+			Annotations: map[string]string{
+				"pod.kubernetes.io/lifetime": "30m",
+			},
+			Labels: map[string]string{
+				"job":                          "1",
+				"app.kubernetes.io/managed-by": "open-ondemand",
+			},
+		},
+		Status: v1.PodStatus{
+			StartTime: &podStartTime,
+		},
+	})
+	// Deleting the pod starts its graceful termination instead of removing it
+	deletes := 0
+	clientset.PrependReactor("delete", "pods", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		deletes++
+		deleteAction := action.(clienttesting.DeleteAction)
+		gvr := v1.SchemeGroupVersion.WithResource("pods")
+		obj, err := clientset.Tracker().Get(gvr, deleteAction.GetNamespace(), deleteAction.GetName())
+		if err != nil {
+			return true, nil, err
+		}
+		pod := obj.(*v1.Pod).DeepCopy()
+		now := metav1.NewTime(timeNow())
+		pod.DeletionTimestamp = &now
+		return true, nil, clientset.Tracker().Update(gvr, pod, pod.Namespace)
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	watcher := newPodWatcher(clientset, []string{metav1.NamespaceAll}, logger)
+	defer watcher.queue.ShutDown()
+	for _, factory := range watcher.factories {
+		factory.Start(ctx.Done())
+	}
+	for _, informer := range watcher.informers {
+		if !cache.WaitForCacheSync(ctx.Done(), informer.HasSynced) {
+			t.Fatal("Timed out waiting for caches to sync")
+		}
+	}
+
+	key := "user-user1/ondemand-job1"
+	if err := watcher.reapPod(ctx, key); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	err := wait.PollImmediate(10*time.Millisecond, 5*time.Second, func() (bool, error) {
+		pod, ok := watcher.getPod(key)
+		return ok && pod.DeletionTimestamp != nil, nil
+	})
+	if err != nil {
+		t.Fatal("Timed out waiting for terminating pod update")
+	}
+	for i := 0; i < 3; i++ {
+		if err := watcher.reapPod(ctx, key); err != nil {
+			t.Errorf("Unexpected error: %v", err)
+		}
+	}
+	if deletes != 1 {
+		t.Errorf("Expected terminating pod to be deleted once, got: %d", deletes)
+	}
+}
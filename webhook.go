@@ -0,0 +1,463 @@
+// Copyright 2020 Ohio Supercomputer Center
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	admissionv1 "k8s.io/api/admission/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	webhookActionDeny string = "deny"
+	webhookActionWarn string = "warn"
+)
+
+var (
+	webhookActionValid = []string{webhookActionDeny, webhookActionWarn}
+	// webhookLock prevents configuration reloads while a request is reviewed
+	webhookLock sync.RWMutex
+)
+
+// admissionHandler reviews the pod of an admission request
+type admissionHandler func(pod *v1.Pod, request *admissionv1.AdmissionRequest, logger log.Logger) *admissionv1.AdmissionResponse
+
+// runWebhook serves the admission webhooks until ctx is done, namespace lifetime
+// policies and the configuration file are refreshed every --reap-interval
+func runWebhook(ctx context.Context, clientset kubernetes.Interface, logger log.Logger) {
+	webhookLogger := log.With(logger, "address", *webhookListenAddress)
+	var cert tls.Certificate
+	var err error
+	if *webhookSelfSigned {
+		cert, err = selfSignedCertificate(ctx, clientset, logger)
+	} else {
+		cert, err = tls.LoadX509KeyPair(*webhookCertFile, *webhookKeyFile)
+	}
+	if err != nil && ctx.Err() != nil {
+		level.Info(webhookLogger).Log("msg", "Shutting down, not serving admission requests")
+		return
+	}
+	if err != nil {
+		level.Error(webhookLogger).Log("msg", "Error loading webhook certificate", "err", err)
+		os.Exit(1)
+	}
+	go wait.Until(func() {
+		webhookLock.Lock()
+		reloadConfig(logger)
+		webhookLock.Unlock()
+		// Requests are not held up while listing, namespace lifetime policies have their own lock
+		if _, err := getNamespaces(ctx, clientset, logger); err != nil {
+			level.Error(logger).Log("msg", "Error refreshing namespaces", "err", err)
+		}
+	}, *reapInterval, ctx.Done())
+
+	mux := http.NewServeMux()
+	mux.Handle("/validate", admissionServer("validate", validatePod, logger))
+	mux.Handle("/mutate", admissionServer("mutate", mutatePod, logger))
+	server := &http.Server{
+		Addr:      *webhookListenAddress,
+		Handler:   mux,
+		TLSConfig: &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12},
+	}
+	go func() {
+		<-ctx.Done()
+		//nolint:errcheck
+		server.Shutdown(context.Background())
+	}()
+	level.Info(webhookLogger).Log("msg", "Listening for admission requests")
+	if err := server.ListenAndServeTLS("", ""); err != nil && err != http.ErrServerClosed {
+		level.Error(webhookLogger).Log("msg", "Error starting webhook server", "err", err)
+		os.Exit(1)
+	}
+}
+
+// admissionServer decodes AdmissionReview requests for pods and responds with the review of handler
+func admissionServer(name string, handler admissionHandler, logger log.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		review := admissionv1.AdmissionReview{}
+		if err := json.Unmarshal(body, &review); err != nil || review.Request == nil {
+			level.Error(logger).Log("msg", "Error decoding admission review", "webhook", name, "err", err)
+			http.Error(w, "invalid admission review", http.StatusBadRequest)
+			return
+		}
+		request := review.Request
+		reviewLogger := log.With(logger, "webhook", name, "pod", request.Name, "namespace", request.Namespace, "operation", request.Operation)
+		pod := &v1.Pod{}
+		var response *admissionv1.AdmissionResponse
+		if err := json.Unmarshal(request.Object.Raw, pod); err != nil {
+			level.Error(reviewLogger).Log("msg", "Error decoding pod", "err", err)
+			response = &admissionv1.AdmissionResponse{
+				Result: &metav1.Status{Status: metav1.StatusFailure, Code: http.StatusBadRequest, Message: err.Error()},
+			}
+		} else {
+			if pod.Namespace == "" {
+				pod.Namespace = request.Namespace
+			}
+			webhookLock.RLock()
+			response = handler(pod, request, reviewLogger)
+			webhookLock.RUnlock()
+		}
+		response.UID = request.UID
+		metricWebhookRequests.WithLabelValues(name, fmt.Sprintf("%t", response.Allowed)).Inc()
+		review.Request = nil
+		review.Response = response
+		data, err := json.Marshal(review)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		//nolint:errcheck
+		w.Write(data)
+	})
+}
+
+// validatePod rejects, or warns about, pods with a lifetime or expires-at annotation that can not be
+// parsed or exceeds the max lifetime and pods missing a lifetime in namespaces that require one,
+// only pod creation is reviewed so existing pods can always be updated
+func validatePod(pod *v1.Pod, request *admissionv1.AdmissionRequest, logger log.Logger) *admissionv1.AdmissionResponse {
+	if request.Operation != admissionv1.Create {
+		level.Debug(logger).Log("msg", "Not reviewing pod operation other than create")
+		return &admissionv1.AdmissionResponse{Allowed: true}
+	}
+	problems := podProblems(pod)
+	if len(problems) == 0 {
+		return &admissionv1.AdmissionResponse{Allowed: true}
+	}
+	level.Info(logger).Log("msg", "Pod lifetime is invalid", "action", *webhookAction, "problems", strings.Join(problems, "; "))
+	if *webhookAction == webhookActionWarn {
+		return &admissionv1.AdmissionResponse{Allowed: true, Warnings: problems}
+	}
+	return &admissionv1.AdmissionResponse{
+		Allowed: false,
+		Result: &metav1.Status{
+			Status:  metav1.StatusFailure,
+			Code:    http.StatusForbidden,
+			Reason:  metav1.StatusReasonForbidden,
+			Message: strings.Join(problems, "; "),
+		},
+	}
+}
+
+func podProblems(pod *v1.Pod) []string {
+	problems := []string{}
+	lifetimeValue, hasLifetime := pod.Annotations[lifetimeAnnotation]
+	if hasLifetime {
+		lifetime, err := parseLifetime(lifetimeValue)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("invalid %s annotation %q: %v", lifetimeAnnotation, lifetimeValue, err))
+		} else if max := maxLifetimeFor(pod.Namespace); max != 0 && lifetime > max {
+			problems = append(problems, fmt.Sprintf("%s annotation %s exceeds the max lifetime %s", lifetimeAnnotation, lifetimeValue, max))
+		}
+	}
+	expiresAtValue, hasExpiresAt := pod.Annotations[expiresAtAnnotation]
+	if hasExpiresAt {
+		expiresAt, err := time.Parse(time.RFC3339, expiresAtValue)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("invalid %s annotation %q: %v", expiresAtAnnotation, expiresAtValue, err))
+		} else if max := maxLifetimeFor(pod.Namespace); max != 0 {
+			// The creation timestamp is not always set when the pod is reviewed
+			created := pod.CreationTimestamp.Time
+			if created.IsZero() {
+				created = timeNow()
+			}
+			if expiresAt.After(created.Add(max)) {
+				problems = append(problems, fmt.Sprintf("%s annotation %s exceeds the max lifetime %s", expiresAtAnnotation, expiresAtValue, max))
+			}
+		}
+	}
+	if !hasLifetime && !hasExpiresAt && sliceContains(strings.Split(*webhookRequireLifetime, ","), pod.Namespace) &&
+		namespacePolicies.get(pod.Namespace).defaultLifetime == 0 {
+		problems = append(problems, fmt.Sprintf("namespace %s requires pods to have the %s annotation", pod.Namespace, lifetimeAnnotation))
+	}
+	return problems
+}
+
+// jsonPatchOperation is an RFC 6902 JSON patch operation
+type jsonPatchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+// mutatePod adds the lifetime annotation and job label to pods matching --webhook-mutate-labels
+// that lack them, the lifetime is the namespace default lifetime or --webhook-default-lifetime
+// capped at the namespace max lifetime. Pods with the expires-at annotation are not given a lifetime.
+func mutatePod(pod *v1.Pod, request *admissionv1.AdmissionRequest, logger log.Logger) *admissionv1.AdmissionResponse {
+	response := &admissionv1.AdmissionResponse{Allowed: true}
+	if !mutateSelectorsMatch(pod) {
+		return response
+	}
+	patch := []jsonPatchOperation{}
+	_, hasLifetime := pod.Annotations[lifetimeAnnotation]
+	_, hasExpiresAt := pod.Annotations[expiresAtAnnotation]
+	if !hasLifetime && !hasExpiresAt {
+		lifetime := namespacePolicies.get(pod.Namespace).defaultLifetime
+		if lifetime == 0 {
+			lifetime = *webhookDefaultLifetime
+		}
+		if max := maxLifetimeFor(pod.Namespace); max != 0 && lifetime > max {
+			level.Debug(logger).Log("msg", "Default lifetime exceeds max lifetime, using max lifetime", "lifetime", lifetime, "max", max)
+			lifetime = max
+		}
+		if lifetime != 0 {
+			level.Info(logger).Log("msg", "Adding lifetime to pod", "lifetime", lifetime)
+			patch = append(patch, addMapEntryPatch("/metadata/annotations", pod.Annotations == nil, lifetimeAnnotation, lifetime.String()))
+		}
+	}
+	if _, ok := pod.Labels[*jobLabel]; !ok {
+		if owner := metav1.GetControllerOf(pod); owner != nil {
+			if value, ok := jobLabelValue(owner.Name); ok {
+				level.Info(logger).Log("msg", "Adding job label to pod", "label", *jobLabel, "job", value)
+				patch = append(patch, addMapEntryPatch("/metadata/labels", pod.Labels == nil, *jobLabel, value))
+			} else {
+				level.Info(logger).Log("msg", "Pod owner name is not a valid label value, not adding job label", "owner", owner.Name)
+			}
+		} else {
+			level.Debug(logger).Log("msg", "Pod lacks job label and has no owner")
+		}
+	}
+	if len(patch) == 0 {
+		return response
+	}
+	data, err := json.Marshal(patch)
+	if err != nil {
+		level.Error(logger).Log("msg", "Error generating pod patch", "err", err)
+		return response
+	}
+	patchType := admissionv1.PatchTypeJSONPatch
+	response.Patch = data
+	response.PatchType = &patchType
+	return response
+}
+
+// jobLabelValue returns the job label value for the name of a pod's owner, names longer
+// than a label value allows are truncated and suffixed with a hash of the full name
+func jobLabelValue(name string) (string, bool) {
+	value := name
+	if len(value) > validation.LabelValueMaxLength {
+		hash := sha256.Sum256([]byte(name))
+		suffix := hex.EncodeToString(hash[:])[:10]
+		prefix := strings.TrimRight(name[:validation.LabelValueMaxLength-len(suffix)-1], "-_.")
+		value = prefix + "-" + suffix
+	}
+	if errs := validation.IsValidLabelValue(value); len(errs) != 0 {
+		return "", false
+	}
+	return value, true
+}
+
+// mutateSelectorsMatch returns whether the pod matches one of --webhook-mutate-labels
+func mutateSelectorsMatch(pod *v1.Pod) bool {
+	for _, l := range strings.Split(*webhookMutateLabels, ",") {
+		selector, err := labels.Parse(l)
+		if err != nil {
+			continue
+		}
+		if selector.Matches(labels.Set(pod.Labels)) {
+			return true
+		}
+	}
+	return false
+}
+
+// addMapEntryPatch returns the patch adding key to the map at path, creating the map if it does not exist
+func addMapEntryPatch(path string, create bool, key string, value string) jsonPatchOperation {
+	if create {
+		return jsonPatchOperation{Op: "add", Path: path, Value: map[string]string{key: value}}
+	}
+	key = strings.ReplaceAll(strings.ReplaceAll(key, "~", "~0"), "/", "~1")
+	return jsonPatchOperation{Op: "add", Path: path + "/" + key, Value: value}
+}
+
+// selfSignedCertificate generates a serving certificate for the webhook Service signed by the
+// webhook CA and sets the CA bundle of the webhook configuration to the CA
+func selfSignedCertificate(ctx context.Context, clientset kubernetes.Interface, logger log.Logger) (tls.Certificate, error) {
+	caCert, caKey, caBundle, err := webhookCA(ctx, clientset, logger)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	now := timeNow()
+	service := fmt.Sprintf("%s.%s.svc", *webhookServiceName, *webhookServiceNamespace)
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: service},
+		DNSNames:     []string{*webhookServiceName, fmt.Sprintf("%s.%s", *webhookServiceName, *webhookServiceNamespace), service, service + ".cluster.local"},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(10 * 365 * day),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, caCert, &key.PublicKey, caKey)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	cert, err := tls.X509KeyPair(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	if err := setWebhookCABundle(ctx, clientset, caBundle, logger); err != nil {
+		return tls.Certificate{}, err
+	}
+	return cert, nil
+}
+
+// webhookCA returns the CA stored in the Secret --webhook-ca-secret-name and its PEM certificate,
+// the CA is generated and stored when the Secret does not exist so every replica uses the same CA
+func webhookCA(ctx context.Context, clientset kubernetes.Interface, logger log.Logger) (*x509.Certificate, interface{}, []byte, error) {
+	secrets := clientset.CoreV1().Secrets(*webhookServiceNamespace)
+	secret, err := secrets.Get(ctx, *webhookCASecretName, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		var certPEM, keyPEM []byte
+		certPEM, keyPEM, err = generateCA()
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		secret = &v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: *webhookCASecretName, Namespace: *webhookServiceNamespace},
+			Type:       v1.SecretTypeTLS,
+			Data:       map[string][]byte{v1.TLSCertKey: certPEM, v1.TLSPrivateKeyKey: keyPEM},
+		}
+		_, err = secrets.Create(ctx, secret, metav1.CreateOptions{})
+		if errors.IsAlreadyExists(err) {
+			// Another replica stored its CA first
+			secret, err = secrets.Get(ctx, *webhookCASecretName, metav1.GetOptions{})
+		} else if err == nil {
+			level.Info(logger).Log("msg", "Stored webhook CA", "secret", *webhookCASecretName, "namespace", *webhookServiceNamespace)
+		}
+	}
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("unable to get webhook CA secret %s: %v", *webhookCASecretName, err)
+	}
+	ca, err := tls.X509KeyPair(secret.Data[v1.TLSCertKey], secret.Data[v1.TLSPrivateKeyKey])
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("invalid webhook CA secret %s: %v", *webhookCASecretName, err)
+	}
+	caCert, err := x509.ParseCertificate(ca.Certificate[0])
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("invalid webhook CA secret %s: %v", *webhookCASecretName, err)
+	}
+	return caCert, ca.PrivateKey, secret.Data[v1.TLSCertKey], nil
+}
+
+// generateCA generates a CA for the webhook and returns its PEM certificate and key
+func generateCA() ([]byte, []byte, error) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	now := timeNow()
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "job-pod-reaper-webhook-ca"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(10 * 365 * day),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(caKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), nil
+}
+
+// setWebhookCABundle sets the CA bundle of the webhooks of the validating and
+// mutating webhook configurations named --webhook-configuration-name
+func setWebhookCABundle(ctx context.Context, clientset kubernetes.Interface, caBundle []byte, logger log.Logger) error {
+	found := false
+	validating := clientset.AdmissionregistrationV1().ValidatingWebhookConfigurations()
+	validatingConfig, err := validating.Get(ctx, *webhookConfigName, metav1.GetOptions{})
+	if err == nil {
+		found = true
+		for i := range validatingConfig.Webhooks {
+			validatingConfig.Webhooks[i].ClientConfig.CABundle = caBundle
+		}
+		if _, err := validating.Update(ctx, validatingConfig, metav1.UpdateOptions{}); err != nil {
+			return fmt.Errorf("unable to update validating webhook configuration %s: %v", *webhookConfigName, err)
+		}
+		level.Info(logger).Log("msg", "Updated validating webhook configuration CA bundle", "webhook_configuration", *webhookConfigName)
+	} else if !errors.IsNotFound(err) {
+		return fmt.Errorf("unable to get validating webhook configuration %s: %v", *webhookConfigName, err)
+	}
+	mutating := clientset.AdmissionregistrationV1().MutatingWebhookConfigurations()
+	mutatingConfig, err := mutating.Get(ctx, *webhookConfigName, metav1.GetOptions{})
+	if err == nil {
+		found = true
+		for i := range mutatingConfig.Webhooks {
+			mutatingConfig.Webhooks[i].ClientConfig.CABundle = caBundle
+		}
+		if _, err := mutating.Update(ctx, mutatingConfig, metav1.UpdateOptions{}); err != nil {
+			return fmt.Errorf("unable to update mutating webhook configuration %s: %v", *webhookConfigName, err)
+		}
+		level.Info(logger).Log("msg", "Updated mutating webhook configuration CA bundle", "webhook_configuration", *webhookConfigName)
+	} else if !errors.IsNotFound(err) {
+		return fmt.Errorf("unable to get mutating webhook configuration %s: %v", *webhookConfigName, err)
+	}
+	if !found {
+		return fmt.Errorf("no validating or mutating webhook configuration named %s", *webhookConfigName)
+	}
+	return nil
+}
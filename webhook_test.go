@@ -0,0 +1,300 @@
+// Copyright 2020 Ohio Supercomputer Center
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+	admissionv1 "k8s.io/api/admission/v1"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func admissionReview(t *testing.T, handler http.Handler, pod *v1.Pod) *admissionv1.AdmissionResponse {
+	raw, err := json.Marshal(pod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	review := admissionv1.AdmissionReview{
+		TypeMeta: metav1.TypeMeta{APIVersion: "admission.k8s.io/v1", Kind: "AdmissionReview"},
+		Request: &admissionv1.AdmissionRequest{
+			UID:       types.UID("test"),
+			Name:      pod.Name,
+			Namespace: pod.Namespace,
+			Operation: admissionv1.Create,
+			Object:    runtime.RawExtension{Raw: raw},
+		},
+	}
+	body, err := json.Marshal(review)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/validate", bytes.NewReader(body)))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Unexpected status code, got: %d", recorder.Code)
+	}
+	response := admissionv1.AdmissionReview{}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if response.Response == nil || response.Response.UID != "test" {
+		t.Fatalf("Unexpected admission response: %v", response.Response)
+	}
+	return response.Response
+}
+
+func webhookPod(namespace string, annotations map[string]string) *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "ondemand-job1",
+			Namespace:   namespace,
+			Annotations: annotations,
+		},
+	}
+}
+
+func TestValidatePod(t *testing.T) {
+	if _, err := kingpin.CommandLine.Parse([]string{"--max-lifetime=24h", "--webhook-require-lifetime-namespaces=user-user1,user-user2"}); err != nil {
+		t.Fatal(err)
+	}
+	handler := admissionServer("validate", validatePod, log.NewNopLogger())
+
+	tests := []struct {
+		pod     *v1.Pod
+		allowed bool
+	}{
+		{webhookPod("user-user1", map[string]string{lifetimeAnnotation: "8h"}), true},
+		{webhookPod("user-user1", map[string]string{expiresAtAnnotation: "2020-01-01T14:00:00Z"}), true},
+		{webhookPod("user-user3", nil), true},
+		{webhookPod("user-user1", map[string]string{lifetimeAnnotation: "8 hours"}), false},
+		{webhookPod("user-user1", map[string]string{lifetimeAnnotation: "2d"}), false},
+		{webhookPod("user-user1", map[string]string{expiresAtAnnotation: "tomorrow"}), false},
+		{webhookPod("user-user1", map[string]string{expiresAtAnnotation: timeNow().Add(48 * time.Hour).Format(time.RFC3339)}), false},
+		{webhookPod("user-user2", nil), false},
+	}
+	for _, test := range tests {
+		response := admissionReview(t, handler, test.pod)
+		if response.Allowed != test.allowed {
+			t.Errorf("Unexpected allowed for %s %v, got: %t", test.pod.Namespace, test.pod.Annotations, response.Allowed)
+		}
+		if !response.Allowed && (response.Result == nil || response.Result.Message == "") {
+			t.Errorf("Expected message for denied pod %s %v", test.pod.Namespace, test.pod.Annotations)
+		}
+	}
+
+	update := &admissionv1.AdmissionRequest{Operation: admissionv1.Update}
+	if response := validatePod(webhookPod("user-user1", map[string]string{lifetimeAnnotation: "2d"}), update, log.NewNopLogger()); !response.Allowed {
+		t.Errorf("Expected pod update to be allowed, got: %v", response.Result)
+	}
+
+	if _, err := kingpin.CommandLine.Parse([]string{"--webhook-action=warn"}); err != nil {
+		t.Fatal(err)
+	}
+	response := admissionReview(t, handler, webhookPod("user-user1", map[string]string{lifetimeAnnotation: "8 hours"}))
+	if !response.Allowed || len(response.Warnings) != 1 {
+		t.Errorf("Expected pod to be allowed with warning, got: %t %v", response.Allowed, response.Warnings)
+	}
+}
+
+func TestSelfSignedCertificate(t *testing.T) {
+	if _, err := kingpin.CommandLine.Parse([]string{"--webhook-self-signed"}); err != nil {
+		t.Fatal(err)
+	}
+	clientset := fake.NewSimpleClientset(&admissionregistrationv1.ValidatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "job-pod-reaper",
+		},
+		Webhooks: []admissionregistrationv1.ValidatingWebhook{
+			{Name: "lifetime.job-pod-reaper.osc.edu"},
+		},
+	})
+	cert, err := selfSignedCertificate(context.TODO(), clientset, log.NewNopLogger())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	config, err := clientset.AdmissionregistrationV1().ValidatingWebhookConfigurations().Get(context.TODO(), "job-pod-reaper", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(config.Webhooks[0].ClientConfig.CABundle)
+	if block == nil {
+		t.Fatal("Expected CA bundle to be set")
+	}
+	ca, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := leaf.Verify(x509.VerifyOptions{
+		DNSName:     "job-pod-reaper-webhook.job-pod-reaper.svc",
+		Roots:       roots,
+		CurrentTime: timeNow(),
+	}); err != nil {
+		t.Errorf("Unexpected error verifying certificate: %v", err)
+	}
+
+	// Another replica reuses the CA stored in the secret
+	if _, err := clientset.CoreV1().Secrets("job-pod-reaper").Get(context.TODO(), "job-pod-reaper-webhook-ca", metav1.GetOptions{}); err != nil {
+		t.Errorf("Expected CA secret to be created, got: %v", err)
+	}
+	cert, err = selfSignedCertificate(context.TODO(), clientset, log.NewNopLogger())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	updated, err := clientset.AdmissionregistrationV1().ValidatingWebhookConfigurations().Get(context.TODO(), "job-pod-reaper", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(updated.Webhooks[0].ClientConfig.CABundle, config.Webhooks[0].ClientConfig.CABundle) {
+		t.Errorf("Expected CA bundle to be unchanged")
+	}
+	leaf, err = x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := leaf.Verify(x509.VerifyOptions{
+		DNSName:     "job-pod-reaper-webhook.job-pod-reaper.svc",
+		Roots:       roots,
+		CurrentTime: timeNow(),
+	}); err != nil {
+		t.Errorf("Unexpected error verifying certificate of second replica: %v", err)
+	}
+}
+
+func TestMutatePod(t *testing.T) {
+	if _, err := kingpin.CommandLine.Parse([]string{"--webhook-mutate-labels=app.kubernetes.io/managed-by=open-ondemand", "--webhook-default-lifetime=8h"}); err != nil {
+		t.Fatal(err)
+	}
+	namespacePolicies.set(map[string]namespacePolicy{"user-user2": {defaultLifetime: 2 * time.Hour}, "user-user3": {maxLifetime: 4 * time.Hour}})
+	defer namespacePolicies.set(make(map[string]namespacePolicy))
+	handler := admissionServer("mutate", mutatePod, log.NewNopLogger())
+	isController := true
+	owner := []metav1.OwnerReference{{APIVersion: "batch/v1", Kind: "Job", Name: "ondemand-job1", Controller: &isController}}
+
+	tests := []struct {
+		pod      *v1.Pod
+		expected []jsonPatchOperation
+	}{
+		{
+			pod: &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod1", Namespace: "user-user1", OwnerReferences: owner,
+				Labels: map[string]string{"app.kubernetes.io/managed-by": "open-ondemand"}}},
+			expected: []jsonPatchOperation{
+				{Op: "add", Path: "/metadata/annotations", Value: map[string]interface{}{lifetimeAnnotation: "8h0m0s"}},
+				{Op: "add", Path: "/metadata/labels/job", Value: "ondemand-job1"},
+			},
+		},
+		{
+			pod: &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod2", Namespace: "user-user2",
+				Annotations: map[string]string{"foo": "bar"},
+				Labels:      map[string]string{"app.kubernetes.io/managed-by": "open-ondemand", "job": "2"}}},
+			expected: []jsonPatchOperation{
+				{Op: "add", Path: "/metadata/annotations/pod.kubernetes.io~1lifetime", Value: "2h0m0s"},
+			},
+		},
+		{
+			pod: &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod3", Namespace: "user-user1",
+				Annotations: map[string]string{lifetimeAnnotation: "1h"},
+				Labels:      map[string]string{"app.kubernetes.io/managed-by": "open-ondemand", "job": "3"}}},
+		},
+		{
+			pod: &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod4", Namespace: "user-user1", OwnerReferences: owner}},
+		},
+		{
+			pod: &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod5", Namespace: "user-user1",
+				Annotations: map[string]string{lifetimeAnnotation: "1h"},
+				Labels:      map[string]string{"app.kubernetes.io/managed-by": "open-ondemand"},
+				OwnerReferences: []metav1.OwnerReference{{APIVersion: "batch/v1", Kind: "Job", Name: strings.Repeat("ondemand-", 20) + "job5",
+					Controller: &isController}}}},
+			expected: []jsonPatchOperation{
+				{Op: "add", Path: "/metadata/labels/job", Value: "ondemand-ondemand-ondemand-ondemand-ondemand-ondeman-d569f5867f"},
+			},
+		},
+		{
+			pod: &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod6", Namespace: "user-user3",
+				Annotations: map[string]string{"foo": "bar"},
+				Labels:      map[string]string{"app.kubernetes.io/managed-by": "open-ondemand", "job": "6"}}},
+			expected: []jsonPatchOperation{
+				{Op: "add", Path: "/metadata/annotations/pod.kubernetes.io~1lifetime", Value: "4h0m0s"},
+			},
+		},
+		{
+			pod: &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod7", Namespace: "user-user1",
+				Annotations: map[string]string{expiresAtAnnotation: "2020-01-01T20:00:00Z"},
+				Labels:      map[string]string{"app.kubernetes.io/managed-by": "open-ondemand", "job": "7"}}},
+		},
+	}
+	for _, test := range tests {
+		response := admissionReview(t, handler, test.pod)
+		if !response.Allowed {
+			t.Errorf("Expected pod %s to be allowed", test.pod.Name)
+		}
+		patch := []jsonPatchOperation{}
+		if response.Patch != nil {
+			if err := json.Unmarshal(response.Patch, &patch); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if len(test.expected) == 0 {
+			test.expected = []jsonPatchOperation{}
+		}
+		if !reflect.DeepEqual(patch, test.expected) {
+			t.Errorf("Unexpected patch for pod %s\nGot: %v\nExpected: %v", test.pod.Name, patch, test.expected)
+		}
+	}
+}
+
+func TestJobLabelValue(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		ok    bool
+	}{
+		{"ondemand-job1", "ondemand-job1", true},
+		{strings.Repeat("a", 63), strings.Repeat("a", 63), true},
+		{strings.Repeat("a", 64), strings.Repeat("a", 52) + "-ffe054fe7a", true},
+		{strings.Repeat("ondemand-", 20), "ondemand-ondemand-ondemand-ondemand-ondemand-ondeman-bc76212179", true},
+		{"job:1", "", false},
+	}
+	for _, test := range tests {
+		value, ok := jobLabelValue(test.name)
+		if value != test.value || ok != test.ok {
+			t.Errorf("Unexpected label value for %s, got: %s %t", test.name, value, ok)
+		}
+		if ok && len(value) > 63 {
+			t.Errorf("Label value for %s is too long, got: %s", test.name, value)
+		}
+	}
+}